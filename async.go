@@ -0,0 +1,178 @@
+package onion
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LoadPolicy is what to do when a layer is not loaded in the timeout
+type LoadPolicy int
+
+const (
+	// LoadFail reports the timeout as an error in the Ready
+	LoadFail LoadPolicy = iota
+	// LoadSkip ignores the layer until it is loaded
+	LoadSkip
+	// LoadFallback uses the fallback data until the layer is loaded
+	LoadFallback
+)
+
+// LoadOptions is the options for the async loading of the layers
+type LoadOptions struct {
+	// Timeout is the maximum wait for the initial load of each layer, zero means no timeout
+	Timeout time.Duration
+	// Policy is used when the layer is not loaded in the timeout
+	Policy LoadPolicy
+	// Fallback is the data of the layer until it is loaded, only with the LoadFallback policy
+	Fallback map[string]interface{}
+}
+
+func (o *Onion) startLoad() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.pending == 0 {
+		o.ready = make(chan struct{})
+	}
+	o.pending++
+}
+
+func (o *Onion) finishLoad(err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err != nil {
+		o.loadErrs = append(o.loadErrs, err)
+	}
+	if o.pending--; o.pending == 0 {
+		close(o.ready)
+	}
+}
+
+// lateLoad sets the data of a layer which is loaded after its timeout, its error is not reported
+// by the Ready anymore
+func (o *Onion) lateLoad(l Layer, data map[string]interface{}, err error) {
+	o.setLayerData(l, data, true)
+	if err == nil {
+		return
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for i := range o.loadErrs {
+		if o.loadErrs[i] == err {
+			o.loadErrs = append(o.loadErrs[:i], o.loadErrs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (o *Onion) loadAsync(ctx context.Context, idx int, l Layer, opt LoadOptions) {
+	loaded := make(chan map[string]interface{}, 1)
+	go func() {
+		loaded <- l.Load()
+	}()
+
+	var timeout <-chan time.Time
+	if opt.Timeout > 0 {
+		timer := time.NewTimer(opt.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data := <-loaded:
+		o.setLayerData(l, data, true)
+		o.finishLoad(nil)
+	case <-timeout:
+		var err error
+		if opt.Policy == LoadFail {
+			err = fmt.Errorf("layer %d is not loaded in %s", idx, opt.Timeout)
+		}
+		o.finishLoad(err)
+
+		// The late data is used anyway
+		select {
+		case data := <-loaded:
+			o.lateLoad(l, data, err)
+		case <-ctx.Done():
+			return
+		}
+	case <-ctx.Done():
+		var err error
+		if opt.Policy == LoadFail {
+			err = ctx.Err()
+		}
+		o.finishLoad(err)
+		return
+	}
+
+	o.watchLayer(ctx, l)
+}
+
+// AddLayersAsyncContext add new layers to the global config, see Onion.AddLayersAsyncContext
+func AddLayersAsyncContext(ctx context.Context, opt LoadOptions, l ...Layer) {
+	o.AddLayersAsyncContext(ctx, opt, l...)
+}
+
+// AddLayersAsyncContext add new layers to the end of config layers like the AddLayersContext, but
+// the layers are loaded concurrently and this function does not wait for them. until a layer is
+// loaded it is empty (or has the fallback data). use the Ready to wait for the initial load
+func (o *Onion) AddLayersAsyncContext(ctx context.Context, opt LoadOptions, l ...Layer) {
+	if len(l) == 0 {
+		return
+	}
+	o.lock.Lock()
+	start := len(o.ll)
+	o.ll = append(o.ll, l...)
+	o.lock.Unlock()
+
+	for i := range l {
+		var data map[string]interface{}
+		if opt.Policy == LoadFallback {
+			data = opt.Fallback
+		}
+		o.setLayerData(l[i], data, false)
+		o.startLoad()
+		go o.loadAsync(ctx, start+i, l[i], opt)
+	}
+}
+
+// AddLayersAsync add new layers to the global config, see Onion.AddLayersAsyncContext
+func AddLayersAsync(opt LoadOptions, l ...Layer) {
+	o.AddLayersAsyncContext(context.Background(), opt, l...)
+}
+
+// AddLayersAsync add new layers to onion, see AddLayersAsyncContext
+func (o *Onion) AddLayersAsync(opt LoadOptions, l ...Layer) {
+	o.AddLayersAsyncContext(context.Background(), opt, l...)
+}
+
+// Ready waits for the initial load of the global config layers, see Onion.Ready
+func Ready(ctx context.Context) error {
+	return o.Ready(ctx)
+}
+
+// Ready waits until all the layers added with AddLayersAsyncContext are loaded (or their timeout
+// is passed). it returns the timeouts of the layers with the LoadFail policy which are still not
+// loaded (a MultiError for more than one), or the context error
+func (o *Onion) Ready(ctx context.Context) error {
+	o.lock.RLock()
+	ready := o.ready
+	pending := o.pending
+	o.lock.RUnlock()
+
+	if pending > 0 {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	return joinErrors(o.loadErrs)
+}
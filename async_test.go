@@ -0,0 +1,144 @@
+package onion
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowLayer is loaded after the release
+type slowLayer struct {
+	started chan struct{}
+	release chan struct{}
+	data    map[string]interface{}
+	c       chan map[string]interface{}
+}
+
+func (sl *slowLayer) Load() map[string]interface{} {
+	sl.started <- struct{}{}
+	<-sl.release
+	return sl.data
+}
+
+func (sl *slowLayer) Watch() <-chan map[string]interface{} {
+	return sl.c
+}
+
+func newSlowLayer(key string, value interface{}) *slowLayer {
+	return &slowLayer{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+		data:    map[string]interface{}{key: value},
+		c:       make(chan map[string]interface{}),
+	}
+}
+
+func TestAddLayersAsync(t *testing.T) {
+	Convey("Load the layers concurrently", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		o := New(NewMapLayer(map[string]interface{}{"a": 0, "b": 0}))
+		So(o.Ready(ctx), ShouldBeNil)
+
+		l1, l2 := newSlowLayer("a", 1), newSlowLayer("b", 2)
+		o.AddLayersAsyncContext(ctx, LoadOptions{Timeout: time.Minute}, l1, l2)
+		// Both are loading before any of them is released
+		<-l1.started
+		<-l2.started
+		So(o.GetInt("a"), ShouldEqual, 0)
+		close(l1.release)
+		close(l2.release)
+		So(o.Ready(ctx), ShouldBeNil)
+		So(o.GetInt("a"), ShouldEqual, 1)
+		So(o.GetInt("b"), ShouldEqual, 2)
+
+		// The watch is started after the load
+		watch := o.ReloadWatch()
+		l1.c <- map[string]interface{}{"a": 10}
+		<-watch
+		So(o.GetInt("a"), ShouldEqual, 10)
+	})
+
+	Convey("Timeout policies", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		o := New()
+		skip := newSlowLayer("skip", 1)
+		o.AddLayersAsyncContext(ctx, LoadOptions{Timeout: 10 * time.Millisecond, Policy: LoadSkip}, skip)
+		fallback := newSlowLayer("fallback", "loaded")
+		o.AddLayersAsyncContext(ctx, LoadOptions{
+			Timeout:  10 * time.Millisecond,
+			Policy:   LoadFallback,
+			Fallback: map[string]interface{}{"fallback": "default"},
+		}, fallback)
+		So(o.GetString("fallback"), ShouldEqual, "default")
+
+		So(o.Ready(ctx), ShouldBeNil)
+		_, ok := o.Get("skip")
+		So(ok, ShouldBeFalse)
+		So(o.GetString("fallback"), ShouldEqual, "default")
+
+		close(skip.release)
+		close(fallback.release)
+		for i := 0; i < 500 && (o.GetString("fallback") != "loaded" || o.GetInt("skip") != 1); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(o.GetString("fallback"), ShouldEqual, "loaded")
+		So(o.GetInt("skip"), ShouldEqual, 1)
+
+		fail, fail2 := newSlowLayer("fail", 1), newSlowLayer("fail2", 1)
+		o.AddLayersAsyncContext(ctx, LoadOptions{Timeout: 10 * time.Millisecond}, fail, fail2)
+		err := o.Ready(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "layer 2 is not loaded in 10ms")
+		So(err.Error(), ShouldContainSubstring, "layer 3 is not loaded in 10ms")
+		var me MultiError
+		So(errors.As(err, &me), ShouldBeTrue)
+		So(me, ShouldHaveLength, 2)
+
+		// The late data clears the error
+		close(fail.release)
+		close(fail2.release)
+		for i := 0; i < 500 && o.Ready(ctx) != nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(o.Ready(ctx), ShouldBeNil)
+		So(o.GetInt("fail"), ShouldEqual, 1)
+		So(o.GetInt("fail2"), ShouldEqual, 1)
+
+		slow := newSlowLayer("slow", 1)
+		defer close(slow.release)
+		o.AddLayersAsyncContext(ctx, LoadOptions{}, slow)
+		readyCtx, readyCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer readyCancel()
+		So(errors.Is(o.Ready(readyCtx), context.DeadlineExceeded), ShouldBeTrue)
+	})
+
+	Convey("Cancel the load", t, func() {
+		for policy, want := range map[LoadPolicy]error{
+			LoadFail:     context.Canceled,
+			LoadSkip:     nil,
+			LoadFallback: nil,
+		} {
+			o := New()
+			ctx, cancel := context.WithCancel(context.Background())
+			l := newSlowLayer("a", 1)
+			o.AddLayersAsyncContext(ctx, LoadOptions{Policy: policy}, l)
+			<-l.started
+			cancel()
+
+			err := o.Ready(context.Background())
+			if want == nil {
+				So(err, ShouldBeNil)
+			} else {
+				So(errors.Is(err, want), ShouldBeTrue)
+			}
+			close(l.release)
+		}
+	})
+}
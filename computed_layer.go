@@ -0,0 +1,402 @@
+package onion
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ComputeFunc computes a value from the config, all the values should be read from the getter so
+// the dependencies are tracked. a nil value means the key is not set
+type ComputeFunc func(g *ComputeGetter) (interface{}, error)
+
+// ComputeGetter reads the config for a ComputeFunc and records the keys as the dependencies, the
+// computed keys are computed first so they can depend on each other
+type ComputeGetter struct {
+	round    *computeRound
+	deps     map[string]interface{}
+	computed map[string]struct{}
+	err      error
+}
+
+// Get returns the value of the key, like Onion.Get
+func (g *ComputeGetter) Get(key string) (interface{}, bool) {
+	if _, ok := g.round.cl.funcs[key]; ok {
+		g.computed[key] = struct{}{}
+		v, err := g.round.compute(key)
+		if err != nil {
+			if g.err == nil {
+				g.err = err
+			}
+			return nil, false
+		}
+		return v, v != nil
+	}
+
+	v, ok := g.round.cl.o.Get(key)
+	g.deps[key] = v
+	return v, ok
+}
+
+// GetString is the Onion.GetString with the dependency tracking
+func (g *ComputeGetter) GetString(key string) string {
+	v, _ := g.Get(key)
+	return toString(v, "")
+}
+
+// GetInt is the Onion.GetInt with the dependency tracking
+func (g *ComputeGetter) GetInt(key string) int {
+	return int(g.GetInt64(key))
+}
+
+// GetInt64 is the Onion.GetInt64 with the dependency tracking
+func (g *ComputeGetter) GetInt64(key string) int64 {
+	v, _ := g.Get(key)
+	return toInt64(v, 0)
+}
+
+// GetFloat64 is the Onion.GetFloat64 with the dependency tracking
+func (g *ComputeGetter) GetFloat64(key string) float64 {
+	v, _ := g.Get(key)
+	return toFloat64(v, 0)
+}
+
+// GetBool is the Onion.GetBool with the dependency tracking
+func (g *ComputeGetter) GetBool(key string) bool {
+	v, _ := g.Get(key)
+	return toBool(v, false)
+}
+
+// GetDuration is the Onion.GetDuration with the dependency tracking
+func (g *ComputeGetter) GetDuration(key string) time.Duration {
+	v, _ := g.Get(key)
+	return toDuration(v, 0)
+}
+
+// GetStringSlice is the Onion.GetStringSlice with the dependency tracking
+func (g *ComputeGetter) GetStringSlice(key string) []string {
+	v, _ := g.Get(key)
+	return toStringSlice(v)
+}
+
+// The casts are the same as the Onion getters, the def is returned if the value can not be converted
+
+func toInt64(v interface{}, def int64) int64 {
+	switch nv := v.(type) {
+	case string:
+		// Env is not typed and always is String, so try to convert it to int
+		// if possible
+		i, err := strconv.ParseInt(nv, 10, 64)
+		if err != nil {
+			return def
+		}
+		return i
+	case int:
+		return int64(nv)
+	case int64:
+		return nv
+	case float32:
+		return int64(nv)
+	case float64:
+		return int64(nv)
+	default:
+		return def
+	}
+}
+
+func toFloat64(v interface{}, def float64) float64 {
+	switch nv := v.(type) {
+	case string:
+		// Env is not typed and always is String, so try to convert it to int
+		// if possible
+		f, err := strconv.ParseFloat(nv, 64)
+		if err != nil {
+			return def
+		}
+		return f
+	case int:
+		return float64(nv)
+	case int64:
+		return float64(nv)
+	case float32:
+		return float64(nv)
+	case float64:
+		return nv
+	default:
+		return def
+	}
+}
+
+func toString(v interface{}, def string) string {
+	s, ok := v.(string)
+	if !ok {
+		return def
+	}
+	return s
+}
+
+func toBool(v interface{}, def bool) bool {
+	switch nv := v.(type) {
+	case string:
+		// Env is not typed and always is String, so try to convert it to boolean
+		// if possible
+		i, err := strconv.ParseBool(nv)
+		if err != nil {
+			return def
+		}
+		return i
+	case bool:
+		return nv
+	default:
+		return def
+	}
+}
+
+func toDuration(v interface{}, def time.Duration) time.Duration {
+	switch nv := v.(type) {
+	case string:
+		d, err := time.ParseDuration(nv)
+		if err != nil {
+			return def
+		}
+		return d
+	case int:
+		return time.Duration(nv)
+	case int64:
+		return time.Duration(nv)
+	case time.Duration:
+		return nv
+	default:
+		return def
+	}
+}
+
+// toStringSlice converts a slice of strings, a string is split by comma
+func toStringSlice(v interface{}) []string {
+	switch nv := v.(type) {
+	case string:
+		if len(nv) > 0 {
+			return strings.Split(nv, ",")
+		}
+	case []string:
+		return nv
+	case []interface{}:
+		res := make([]string, len(nv))
+		for i := range nv {
+			var ok bool
+			if res[i], ok = nv[i].(string); !ok {
+				return nil
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// computeRound is one evaluation of the dirty computed keys
+type computeRound struct {
+	cl    *computedLayer
+	done  map[string]bool
+	stack []string
+	errs  map[string]error
+}
+
+func (r *computeRound) compute(key string) (interface{}, error) {
+	if r.done[key] {
+		return r.cl.values[key], nil
+	}
+	if err, ok := r.errs[key]; ok {
+		return nil, err
+	}
+	for i := range r.stack {
+		if r.stack[i] == key {
+			err := fmt.Errorf("computed key cycle: %s", strings.Join(append(r.stack[i:], key), " -> "))
+			r.errs[key] = err
+			return nil, err
+		}
+	}
+
+	r.stack = append(r.stack, key)
+	g := &ComputeGetter{round: r, deps: make(map[string]interface{}), computed: make(map[string]struct{})}
+	v, err := r.cl.funcs[key](g)
+	r.stack = r.stack[:len(r.stack)-1]
+
+	if g.err != nil {
+		// The error of a computed dependency
+		r.errs[key] = g.err
+		return nil, g.err
+	}
+	if err != nil {
+		err = fmt.Errorf("computed key %q: %w", key, err)
+		r.errs[key] = err
+		return nil, err
+	}
+	r.cl.values[key] = v
+	r.cl.deps[key] = g.deps
+	r.cl.computed[key] = g.computed
+	r.done[key] = true
+	return v, nil
+}
+
+type computedLayer struct {
+	o     *Onion
+	funcs map[string]ComputeFunc
+
+	// Only used by the constructor and then the watch goroutine
+	values   map[string]interface{}
+	deps     map[string]map[string]interface{}
+	computed map[string]map[string]struct{}
+
+	lock sync.RWMutex
+	data map[string]interface{}
+	c    chan map[string]interface{}
+}
+
+func (cl *computedLayer) Load() map[string]interface{} {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+
+	return cl.data
+}
+
+func (cl *computedLayer) Watch() <-chan map[string]interface{} {
+	return cl.c
+}
+
+// dirty returns the computed keys with a changed dependency, and the keys which depend on them
+func (cl *computedLayer) dirty() map[string]bool {
+	res := make(map[string]bool)
+	for key, deps := range cl.deps {
+		for dep, old := range deps {
+			if v, _ := cl.o.Get(dep); !reflect.DeepEqual(v, old) {
+				res[key] = true
+				break
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for key, computed := range cl.computed {
+			if res[key] {
+				continue
+			}
+			for dep := range computed {
+				if res[dep] {
+					res[key], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	return res
+}
+
+// update computes the dirty keys (all of them if dirty is nil) and returns the new data
+func (cl *computedLayer) update(dirty map[string]bool) (map[string]interface{}, []error) {
+	r := &computeRound{cl: cl, done: make(map[string]bool), errs: make(map[string]error)}
+	keys := make([]string, 0, len(cl.funcs))
+	for key := range cl.funcs {
+		r.done[key] = dirty != nil && !dirty[key]
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if _, err := r.compute(key); err != nil && !containsError(errs, err) {
+			errs = append(errs, err)
+		}
+	}
+
+	var data map[string]interface{}
+	delimiter := cl.o.GetDelimiter()
+	for key, v := range cl.values {
+		if v != nil {
+			data = buildMap(data, v, strings.Split(key, delimiter)...)
+		}
+	}
+	return data, errs
+}
+
+func containsError(errs []error, err error) bool {
+	for i := range errs {
+		if errs[i] == err {
+			return true
+		}
+	}
+	return false
+}
+
+// watch computes the dirty keys on every change, the reload channel is taken before reading the
+// config so no change is lost
+func (cl *computedLayer) watch(ctx context.Context, reload <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+		}
+		reload = cl.o.ReloadWatch()
+
+		dirty := cl.dirty()
+		if len(dirty) == 0 {
+			continue
+		}
+		data, errs := cl.update(dirty)
+		for _, err := range errs {
+			log.Println("error:", err) // Better log support
+		}
+
+		cl.lock.Lock()
+		same := reflect.DeepEqual(data, cl.data)
+		cl.data = data
+		cl.lock.Unlock()
+		if same {
+			continue
+		}
+
+		select {
+		case cl.c <- data:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// NewComputedLayerContext creates a layer with the values computed from the other layers of the
+// config, the key is the computed key (like db.dsn) and the function computes its value. the
+// layer should be added to the same config as the last layer. when a dependency of a computed key
+// is changed, the key (and the keys depend on it) is computed again until the context is done.
+// the cycles and the errors in the initial compute are returned
+func NewComputedLayerContext(ctx context.Context, o *Onion, funcs map[string]ComputeFunc) (Layer, error) {
+	cl := &computedLayer{
+		o:        o,
+		funcs:    funcs,
+		values:   make(map[string]interface{}),
+		deps:     make(map[string]map[string]interface{}),
+		computed: make(map[string]map[string]struct{}),
+		c:        make(chan map[string]interface{}),
+	}
+
+	reload := o.ReloadWatch()
+	data, errs := cl.update(nil)
+	if err := joinErrors(errs); err != nil {
+		return nil, err
+	}
+	cl.data = data
+
+	go cl.watch(ctx, reload)
+	return cl, nil
+}
+
+// NewComputedLayer creates a new computed layer, see NewComputedLayerContext
+func NewComputedLayer(o *Onion, funcs map[string]ComputeFunc) (Layer, error) {
+	return NewComputedLayerContext(context.Background(), o, funcs)
+}
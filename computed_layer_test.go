@@ -0,0 +1,95 @@
+package onion
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewComputedLayer(t *testing.T) {
+	Convey("Compute the values from the config", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		l, err := NewStreamLayerContext(ctx, bytes.NewBufferString(`{"db": {"host": "localhost", "port": 5432}, "name": "app", "timeout": "2s"}`), "json", nil)
+		So(err, ShouldBeNil)
+		o := NewContext(ctx, l)
+
+		var dsnCalls, nameCalls int32
+		cl, err := NewComputedLayerContext(ctx, o, map[string]ComputeFunc{
+			"db.dsn": func(g *ComputeGetter) (interface{}, error) {
+				atomic.AddInt32(&dsnCalls, 1)
+				return fmt.Sprintf("%s:%d", g.GetString("db.host"), g.GetInt("db.port")), nil
+			},
+			"db.url": func(g *ComputeGetter) (interface{}, error) {
+				return "postgres://" + g.GetString("db.dsn"), nil
+			},
+			"title": func(g *ComputeGetter) (interface{}, error) {
+				atomic.AddInt32(&nameCalls, 1)
+				return g.GetString("name") + " " + g.GetDuration("timeout").String(), nil
+			},
+			"missing": func(g *ComputeGetter) (interface{}, error) {
+				if _, ok := g.Get("unknown"); !ok {
+					return nil, nil
+				}
+				return "set", nil
+			},
+		})
+		So(err, ShouldBeNil)
+		o.AddLayersContext(ctx, cl)
+
+		So(o.GetString("db.dsn"), ShouldEqual, "localhost:5432")
+		So(o.GetString("db.url"), ShouldEqual, "postgres://localhost:5432")
+		So(o.GetString("title"), ShouldEqual, "app 2s")
+		So(o.GetString("db.host"), ShouldEqual, "localhost")
+		_, ok := o.Get("missing")
+		So(ok, ShouldBeFalse)
+		So(atomic.LoadInt32(&dsnCalls), ShouldEqual, 1)
+		So(atomic.LoadInt32(&nameCalls), ShouldEqual, 1)
+
+		So(l.(*streamLayer).Reload(ctx, bytes.NewBufferString(`{"db": {"host": "db.local", "port": 5432}, "name": "app", "timeout": "2s"}`), "json"), ShouldBeNil)
+		for i := 0; i < 100 && o.GetString("db.url") != "postgres://db.local:5432"; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetString("db.dsn"), ShouldEqual, "db.local:5432")
+		So(o.GetString("db.url"), ShouldEqual, "postgres://db.local:5432")
+		So(atomic.LoadInt32(&dsnCalls), ShouldEqual, 2)
+		So(atomic.LoadInt32(&nameCalls), ShouldEqual, 1)
+	})
+
+	Convey("Report the cycles and the errors", t, func() {
+		o := New(NewMapLayer(map[string]interface{}{"a": 1}))
+
+		_, err := NewComputedLayer(o, map[string]ComputeFunc{
+			"x": func(g *ComputeGetter) (interface{}, error) { return g.GetInt("y") + 1, nil },
+			"y": func(g *ComputeGetter) (interface{}, error) { return g.GetInt("z") + 1, nil },
+			"z": func(g *ComputeGetter) (interface{}, error) { return g.GetInt("x") + g.GetInt("a"), nil },
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "computed key cycle: x -> y -> z -> x")
+
+		_, err = NewComputedLayer(o, map[string]ComputeFunc{
+			"b": func(g *ComputeGetter) (interface{}, error) { return nil, errors.New("failed") },
+			"c": func(g *ComputeGetter) (interface{}, error) { return g.GetInt("b"), nil },
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "failed")
+
+		errFirst, errSecond := errors.New("first"), errors.New("second")
+		_, err = NewComputedLayer(o, map[string]ComputeFunc{
+			"d": func(g *ComputeGetter) (interface{}, error) { return nil, errFirst },
+			"e": func(g *ComputeGetter) (interface{}, error) { return nil, errSecond },
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, `computed key "d": first; computed key "e": second`)
+		So(errors.Is(err, errFirst), ShouldBeTrue)
+		So(errors.Is(err, errSecond), ShouldBeTrue)
+	})
+}
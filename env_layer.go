@@ -1,10 +1,80 @@
 package onion
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// EnvSource is the source of the environment variables for the env layers, the result is in the
+// os.Environ format (KEY=value)
+type EnvSource interface {
+	Environ() ([]string, error)
+}
+
+type processEnv struct{}
+
+func (processEnv) Environ() ([]string, error) {
+	return os.Environ(), nil
+}
+
+type mapEnv map[string]string
+
+func (m mapEnv) Environ() ([]string, error) {
+	res := make([]string, 0, len(m))
+	for k, v := range m {
+		res = append(res, k+"="+v)
+	}
+	return res, nil
+}
+
+type fileEnv string
+
+func (f fileEnv) Environ() ([]string, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+
+	sep := []byte("\n")
+	if bytes.IndexByte(b, 0) >= 0 {
+		sep = []byte{0}
+	}
+	var res []string
+	for _, line := range bytes.Split(b, sep) {
+		if line := strings.TrimRight(string(line), "\r"); line != "" {
+			res = append(res, line)
+		}
+	}
+	return res, nil
+}
+
+// ProcessEnv is the environment of the current process
+func ProcessEnv() EnvSource {
+	return processEnv{}
+}
+
+// MapEnv is an environment based on a map, the map is read on each load so it should not be
+// changed concurrently
+func MapEnv(m map[string]string) EnvSource {
+	return mapEnv(m)
+}
+
+// FileEnv reads the environment from a file, the file is either NUL separated (like
+// /proc/<pid>/environ) or one variable per line
+func FileEnv(path string) EnvSource {
+	return fileEnv(path)
+}
+
 func buildMap(m map[string]interface{}, v interface{}, k ...string) map[string]interface{} {
 	if m == nil {
 		m = make(map[string]interface{})
@@ -22,11 +92,39 @@ func buildMap(m map[string]interface{}, v interface{}, k ...string) map[string]i
 	return m
 }
 
+// splitEnviron splits the variables into key value pairs, the order is kept
+func splitEnviron(env []string) [][2]string {
+	res := make([][2]string, 0, len(env))
+	for i := range env {
+		parts := strings.SplitN(env[i], "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		res = append(res, [2]string{parts[0], parts[1]})
+	}
+	return res
+}
+
+// environ reads the source for the constructors without an error result, the errors are logged
+func environ(src EnvSource) [][2]string {
+	env, err := src.Environ()
+	if err != nil {
+		log.Println("error:", err) // Better log support
+		return nil
+	}
+	return splitEnviron(env)
+}
+
 // NewEnvLayer create new layer using the whitelist of environment values.
 func NewEnvLayer(separator string, whiteList ...string) Layer {
+	env := make(map[string]string)
+	for _, kv := range environ(ProcessEnv()) {
+		env[kv[0]] = kv[1]
+	}
+
 	var data map[string]interface{}
 	for i := range whiteList {
-		if v, ok := os.LookupEnv(whiteList[i]); ok {
+		if v, ok := env[whiteList[i]]; ok {
 			keys := strings.Split(strings.ToLower(whiteList[i]), separator)
 			data = buildMap(data, v, keys...)
 		}
@@ -35,18 +133,25 @@ func NewEnvLayer(separator string, whiteList ...string) Layer {
 	return NewMapLayer(data)
 }
 
-// NewEnvLayerPrefix create new env layer, with all values with the same prefix
-// TODO: No prefix loading
-func NewEnvLayerPrefix(separator string, prefix string) Layer {
-	var data map[string]interface{}
+// prefixEnv calls the fn for all the variables with the prefix, the name is lower case and without
+// the prefix
+func prefixEnv(src EnvSource, separator, prefix string, fn func(name, value string)) {
 	pf := strings.ToUpper(prefix) + separator
-	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, pf) {
-			k := strings.Trim(strings.Split(env, "=")[0], "\t\n ")
-			ck := strings.ToLower(strings.TrimPrefix(k, pf))
-			data = buildMap(data, os.Getenv(k), strings.Split(ck, separator)...)
+	for _, kv := range environ(src) {
+		if strings.HasPrefix(kv[0], pf) {
+			k := strings.Trim(kv[0], "\t\n ")
+			fn(strings.ToLower(strings.TrimPrefix(k, pf)), kv[1])
 		}
 	}
+}
+
+// NewEnvLayerPrefix create new env layer, with all values with the same prefix
+// for loading without prefix see NewEnvLayerOptions
+func NewEnvLayerPrefix(separator string, prefix string) Layer {
+	var data map[string]interface{}
+	prefixEnv(ProcessEnv(), separator, prefix, func(name, value string) {
+		data = buildMap(data, value, strings.Split(name, separator)...)
+	})
 
 	return NewMapLayer(data)
 }
@@ -56,13 +161,231 @@ func NewEnvLayerPrefix(separator string, prefix string) Layer {
 // such as csv or ini instead of json or yaml.
 func NewFlatEnvLayerPrefix(separator string, prefix string) Layer {
 	var data map[string]interface{}
-	pf := strings.ToUpper(prefix) + separator
-	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, pf) {
-			k := strings.Trim(strings.Split(env, "=")[0], "\t\n ")
-			ck := strings.ToLower(strings.TrimPrefix(k, pf))
-			data = buildMap(data, os.Getenv(k), ck)
+	prefixEnv(ProcessEnv(), separator, prefix, func(name, value string) {
+		data = buildMap(data, value, name)
+	})
+	return NewMapLayer(data)
+}
+
+// EnvOptions is the options for NewEnvLayerOptions
+type EnvOptions struct {
+	// Prefix is the prefix of the variables, empty prefix means all the variables
+	Prefix string
+	// Separator is used after the prefix and also between nested keys, default is "_". use "__" to
+	// load APP__DB__MAX_CONN as db.max_conn
+	Separator string
+	// KeyFunc converts the variable name (without the prefix and separator) into the key path, the
+	// default is lower case name split by the separator
+	KeyFunc func(name string) []string
+	// InferTypes try to convert the values into json objects and arrays, numbers and booleans
+	InferTypes bool
+	// IndexedLists converts the keys with only numeric children into lists, so APP_HOSTS_0 and
+	// APP_HOSTS_1 are the hosts list
+	IndexedLists bool
+	// Secrets is the list of variable names (like APP_DB_PASSWORD) which are secret, see SecretLayer
+	Secrets []string
+	// Source is the environment source, default is the process environment
+	Source EnvSource
+	// ReloadSignals are the signals to reload the layer (like syscall.SIGHUP), the layer listens
+	// to the signals until the context is done
+	ReloadSignals []os.Signal
+}
+
+type envLayer struct {
+	lock    sync.RWMutex
+	opt     EnvOptions
+	data    map[string]interface{}
+	secrets [][]string
+	sender  *Sender
+}
+
+func (e *envLayer) Load() map[string]interface{} {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.data
+}
+
+func (e *envLayer) Watch() <-chan map[string]interface{} {
+	return e.sender.Watch()
+}
+
+func (e *envLayer) SecretKeys() [][]string {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.secrets
+}
+
+// ReloadLayer reads the environment source again, if there is any change the new data is sent to
+// the watch channel
+func (e *envLayer) ReloadLayer(_ context.Context) error {
+	environ, err := e.opt.Source.Environ()
+	if err != nil {
+		return err
+	}
+	data, secrets := e.opt.build(environ)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if reflect.DeepEqual(data, e.data) {
+		return nil
+	}
+	e.data, e.secrets = data, secrets
+	e.sender.Send(data)
+	return nil
+}
+
+func (opt *EnvOptions) separator() string {
+	if opt.Separator == "" {
+		return "_"
+	}
+	return opt.Separator
+}
+
+// keyPath returns the key path of the variable, false if the variable is not in the prefix
+func (opt *EnvOptions) keyPath(name string) ([]string, bool) {
+	sep := opt.separator()
+	if opt.Prefix != "" {
+		pf := strings.ToUpper(opt.Prefix) + sep
+		if !strings.HasPrefix(name, pf) {
+			return nil, false
 		}
+		name = strings.TrimPrefix(name, pf)
 	}
-	return NewMapLayer(data)
+	if name == "" {
+		return nil, false
+	}
+
+	if opt.KeyFunc != nil {
+		k := opt.KeyFunc(name)
+		return k, len(k) > 0
+	}
+	return strings.Split(strings.ToLower(name), sep), true
+}
+
+func inferType(v string) interface{} {
+	switch strings.ToLower(v) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+		var j interface{}
+		if err := json.Unmarshal([]byte(v), &j); err == nil {
+			return j
+		}
+		return v
+	}
+
+	// The numbers with leading zero (like 007) are usually codes, not numbers
+	if d := strings.TrimLeft(v, "+-"); len(d) > 1 && d[0] == '0' && d[1] >= '0' && d[1] <= '9' {
+		return v
+	}
+	if i, err := strconv.Atoi(v); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	return v
+}
+
+// indexedLists converts the maps with numeric keys into slices, sorted by the index
+func indexedLists(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	idx := make([]int, 0, len(m))
+	for k := range m {
+		m[k] = indexedLists(m[k])
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 {
+			idx = nil
+			continue
+		}
+		if idx != nil {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) != len(m) || len(m) == 0 {
+		return m
+	}
+
+	sort.Ints(idx)
+	res := make([]interface{}, len(idx))
+	for i := range idx {
+		res[i] = m[strconv.Itoa(idx[i])]
+	}
+	return res
+}
+
+func (opt *EnvOptions) build(environ []string) (map[string]interface{}, [][]string) {
+	secrets := make(map[string]bool, len(opt.Secrets))
+	for _, s := range opt.Secrets {
+		secrets[s] = true
+	}
+
+	var (
+		data map[string]interface{}
+		keys [][]string
+	)
+	for _, kv := range splitEnviron(environ) {
+		k, ok := opt.keyPath(kv[0])
+		if !ok {
+			continue
+		}
+
+		var v interface{} = kv[1]
+		if opt.InferTypes {
+			v = inferType(kv[1])
+		}
+		data = buildMap(data, v, k...)
+		if secrets[kv[0]] {
+			keys = append(keys, k)
+		}
+	}
+
+	if opt.IndexedLists && data != nil {
+		for k := range data {
+			data[k] = indexedLists(data[k])
+		}
+	}
+	return data, keys
+}
+
+// NewEnvLayerOptionsContext create a new env layer based on the options, the layer implements the
+// SecretLayer interface for the secret variables. the layer can be reloaded with its ReloadLayer
+// method or by the ReloadSignals until the context is done.
+func NewEnvLayerOptionsContext(ctx context.Context, opt EnvOptions) (Layer, error) {
+	if opt.Source == nil {
+		opt.Source = ProcessEnv()
+	}
+	environ, err := opt.Source.Environ()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &envLayer{
+		opt:    opt,
+		sender: NewSender(ctx),
+	}
+	l.data, l.secrets = opt.build(environ)
+
+	notifySignals(ctx, func() {
+		if err := l.ReloadLayer(ctx); err != nil {
+			log.Println("error:", err) // Better log support
+		}
+	}, opt.ReloadSignals...)
+
+	return l, nil
+}
+
+// NewEnvLayerOptions create a new env layer based on the options, see NewEnvLayerOptionsContext
+func NewEnvLayerOptions(opt EnvOptions) (Layer, error) {
+	return NewEnvLayerOptionsContext(context.Background(), opt)
 }
@@ -1,7 +1,12 @@
 package onion
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -24,3 +29,180 @@ func TestNewEnvLayer(t *testing.T) {
 		So(o3.GetInt("test_sep"), ShouldEqual, 1)
 	})
 }
+
+func TestNewEnvLayerOptions(t *testing.T) {
+	Convey("ENV layer with options", t, func() {
+		env := map[string]string{
+			"ONIONOPT__DB__HOST":     "db.local",
+			"ONIONOPT__DB__MAX_CONN": "10",
+			"ONIONOPT__DEBUG":        "true",
+			"ONIONOPT__RATIO":        "0.5",
+			"ONIONOPT__JSON":         `{"a": [1, 2]}`,
+			"ONIONOPT__BROKEN":       `{"a"`,
+			"ONIONOPT__HOSTS__0":     "h0",
+			"ONIONOPT__HOSTS__1":     "h1",
+			"ONIONOPT__HOSTS__10":    "h10",
+			"ONIONOPT__MIXED__0":     "m0",
+			"ONIONOPT__MIXED__X":     "mx",
+			"ONIONOPT__DB__PASSWORD": "secret",
+		}
+		for k, v := range env {
+			So(os.Setenv(k, v), ShouldBeNil)
+		}
+		defer func() {
+			for k := range env {
+				_ = os.Unsetenv(k)
+			}
+		}()
+
+		Convey("double underscore and types", func() {
+			l, err := NewEnvLayerOptions(EnvOptions{
+				Prefix:       "onionopt",
+				Separator:    "__",
+				InferTypes:   true,
+				IndexedLists: true,
+				Secrets:      []string{"ONIONOPT__DB__PASSWORD"},
+			})
+			So(err, ShouldBeNil)
+			o := New(l)
+			So(o.GetString("db.host"), ShouldEqual, "db.local")
+			v, _ := o.Get("db.max_conn")
+			So(v, ShouldEqual, 10)
+			v, _ = o.Get("debug")
+			So(v, ShouldEqual, true)
+			So(o.GetFloat64("ratio"), ShouldEqual, 0.5)
+			v, _ = o.Get("json.a")
+			So(v, ShouldResemble, []interface{}{float64(1), float64(2)})
+			So(o.GetString("broken"), ShouldEqual, `{"a"`)
+			So(o.GetStringSlice("hosts"), ShouldResemble, []string{"h0", "h1", "h10"})
+			So(o.GetString("mixed.x"), ShouldEqual, "mx")
+			So(o.SecretKeys(), ShouldResemble, []string{"db.password"})
+
+			for in, out := range map[string]interface{}{
+				"0": 0, "-12": -12, "1.5": 1.5, "0.5": 0.5, "007": "007", "-01": "-01",
+				"nan": "nan", "Inf": "Inf", "-infinity": "-infinity",
+			} {
+				So(inferType(in), ShouldEqual, out)
+			}
+		})
+
+		Convey("no prefix and custom key function", func() {
+			l, err := NewEnvLayerOptions(EnvOptions{
+				KeyFunc: func(name string) []string {
+					if !strings.HasPrefix(name, "ONIONOPT__DB__") {
+						return nil
+					}
+					return []string{"database", strings.TrimPrefix(name, "ONIONOPT__DB__")}
+				},
+			})
+			So(err, ShouldBeNil)
+			o := New(l)
+			So(o.GetString("database.HOST"), ShouldEqual, "db.local")
+			v, _ := o.Get("database.MAX_CONN")
+			So(v, ShouldEqual, "10")
+			_, ok := o.Get("onionopt")
+			So(ok, ShouldBeFalse)
+
+			l, err = NewEnvLayerOptions(EnvOptions{Separator: "__"})
+			So(err, ShouldBeNil)
+			o = New(l)
+			So(o.GetString("onionopt.db.host"), ShouldEqual, "db.local")
+			So(o.SecretKeys(), ShouldBeEmpty)
+		})
+	})
+}
+
+// syncEnv is a map source safe to change while the signal reloads the layer
+type syncEnv struct {
+	lock sync.Mutex
+	env  map[string]string
+}
+
+func (s *syncEnv) set(k, v string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.env[k] = v
+}
+
+func (s *syncEnv) Environ() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return MapEnv(s.env).Environ()
+}
+
+type reloadLayer interface {
+	Layer
+	ReloadLayer(context.Context) error
+}
+
+func TestEnvSource(t *testing.T) {
+	Convey("ENV layer with injected source", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("map source and reload on demand", func() {
+			env := map[string]string{"APP_DB_HOST": "h1", "OTHER": "x"}
+			l, err := NewEnvLayerOptionsContext(ctx, EnvOptions{Prefix: "app", Source: MapEnv(env)})
+			So(err, ShouldBeNil)
+			o := NewContext(ctx, l)
+			So(o.GetString("db.host"), ShouldEqual, "h1")
+
+			watch := o.ReloadWatch()
+			env["APP_DB_HOST"] = "h2"
+			So(l.(reloadLayer).ReloadLayer(ctx), ShouldBeNil)
+			<-watch
+			So(o.GetString("db.host"), ShouldEqual, "h2")
+
+			// The context of the reload is only for the reload, the layer sends the data anyway
+			reloadCtx, reloadCancel := context.WithCancel(ctx)
+			reloadCancel()
+			watch = o.ReloadWatch()
+			env["APP_DB_HOST"] = "h3"
+			So(l.(reloadLayer).ReloadLayer(reloadCtx), ShouldBeNil)
+			<-watch
+			So(o.GetString("db.host"), ShouldEqual, "h3")
+		})
+
+		Convey("reload on signal", func() {
+			env := &syncEnv{env: map[string]string{"APP_NAME": "n1"}}
+			l, err := NewEnvLayerOptionsContext(ctx, EnvOptions{
+				Prefix:        "app",
+				Source:        env,
+				ReloadSignals: []os.Signal{syscall.SIGUSR1},
+			})
+			So(err, ShouldBeNil)
+			o := NewContext(ctx, l)
+			So(o.GetString("name"), ShouldEqual, "n1")
+
+			watch := o.ReloadWatch()
+			env.set("APP_NAME", "n2")
+			So(syscall.Kill(os.Getpid(), syscall.SIGUSR1), ShouldBeNil)
+			<-watch
+			So(o.GetString("name"), ShouldEqual, "n2")
+		})
+
+		Convey("file source", func() {
+			f, err := ioutil.TempFile(os.TempDir(), "environ")
+			So(err, ShouldBeNil)
+			defer func() { _ = os.Remove(f.Name()) }()
+			_, err = f.WriteString("APP_A=1\x00APP_B=x=y\x00")
+			So(err, ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			l, err := NewEnvLayerOptions(EnvOptions{Prefix: "app", Source: FileEnv(f.Name())})
+			So(err, ShouldBeNil)
+			o := New(l)
+			So(o.GetInt("a"), ShouldEqual, 1)
+			So(o.GetString("b"), ShouldEqual, "x=y")
+
+			So(ioutil.WriteFile(f.Name(), []byte("APP_A=2\nAPP_C=3\n"), 0600), ShouldBeNil)
+			So(l.(reloadLayer).ReloadLayer(ctx), ShouldBeNil)
+			So(l.Load(), ShouldResemble, map[string]interface{}{"a": "2", "c": "3"})
+
+			_, err = NewEnvLayerOptions(EnvOptions{Source: FileEnv(f.Name() + ".missing")})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
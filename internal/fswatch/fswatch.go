@@ -0,0 +1,187 @@
+// Package fswatch is the shared directory watcher of the file based layers. it only reports that
+// something is changed in the directory, the layers should read their files again and compare the
+// content to find the real changes. this way the editors that save with rename, the atomic
+// symlink swaps and the partial writes are handled the same, with both fsnotify and polling.
+package fswatch
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// settleTime is the quiet time after the last event, before reporting the change
+	settleTime = 100 * time.Millisecond
+	// maxLatency is the maximum wait after the first event, so a file which is written
+	// continuously in the same directory (like a log) does not delay the change forever
+	maxLatency = time.Second
+	// DefaultPollInterval is used when the fsnotify is not available
+	DefaultPollInterval = 2 * time.Second
+)
+
+// WatchFunc is the common signature of the Watch and the Poll functions
+type WatchFunc func(ctx context.Context, dirs ...string) (<-chan struct{}, error)
+
+// Watch watches the directories until the context is done. the returned channel receives a value
+// after a batch of changes in the directories and it is closed when the watch is finished.
+// if the fsnotify is not available it falls back to polling with the DefaultPollInterval
+func Watch(ctx context.Context, dirs ...string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("fsnotify is not available, fallback to polling:", err) // Better log support
+		return Poll(DefaultPollInterval)(ctx, dirs...)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			if _, statErr := os.Stat(dir); statErr != nil {
+				return nil, statErr
+			}
+			log.Println("fsnotify failed, fallback to polling:", err)
+			return Poll(DefaultPollInterval)(ctx, dirs...)
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer func() { _ = watcher.Close() }()
+
+		timer := time.NewTimer(settleTime)
+		timer.Stop()
+		defer timer.Stop()
+
+		// deadline is the latest time to report the pending events, zero if nothing is pending
+		var deadline time.Time
+		settle := func() {
+			if deadline.IsZero() {
+				deadline = time.Now().Add(maxLatency)
+			}
+			wait := time.Until(deadline)
+			if wait > settleTime {
+				wait = settleTime
+			}
+			timer.Reset(wait)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				settle()
+			case <-timer.C:
+				deadline = time.Time{}
+				notify(changes)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// Some events may be lost (like the queue overflow), so check the files anyway
+				log.Println("error:", err) // Better log support
+				settle()
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+// snapshot returns the state of all the files in the directories, the symlinks are followed so a
+// symlink swap changes the state
+func snapshot(dirs ...string) (map[string]fileState, error) {
+	res := make(map[string]fileState)
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			st, err := os.Stat(path)
+			if err != nil {
+				// Broken symlink or removed in the middle
+				st = e
+			}
+			res[path] = fileState{size: st.Size(), modTime: st.ModTime(), mode: st.Mode()}
+		}
+	}
+	return res, nil
+}
+
+func sameState(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.modTime.Equal(v.modTime) || w.size != v.size || w.mode != v.mode {
+			return false
+		}
+	}
+	return true
+}
+
+// Poll returns a WatchFunc that checks the size and the modification time of the files in the
+// directories at every interval, for the file systems without fsnotify support like NFS
+func Poll(interval time.Duration) WatchFunc {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return func(ctx context.Context, dirs ...string) (<-chan struct{}, error) {
+		last, err := snapshot(dirs...)
+		if err != nil {
+			return nil, err
+		}
+
+		changes := make(chan struct{}, 1)
+		go func() {
+			defer close(changes)
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					current, err := snapshot(dirs...)
+					if err != nil {
+						log.Println("error:", err) // Better log support
+						continue
+					}
+					if !sameState(last, current) {
+						last = current
+						notify(changes)
+					}
+				}
+			}
+		}()
+
+		return changes, nil
+	}
+}
+
+func notify(changes chan struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+		// There is already a pending change
+	}
+}
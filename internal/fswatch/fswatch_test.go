@@ -0,0 +1,95 @@
+package fswatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func waitChange(changes <-chan struct{}) bool {
+	select {
+	case _, ok := <-changes:
+		return ok
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestWatchers(t *testing.T) {
+	for name, watch := range map[string]WatchFunc{
+		"fsnotify": Watch,
+		"poll":     Poll(20 * time.Millisecond),
+	} {
+		watch := watch
+		Convey("Test "+name+" watcher", t, func() {
+			dir, err := ioutil.TempDir(os.TempDir(), "onion-fswatch-*")
+			So(err, ShouldBeNil)
+			defer func() { _ = os.RemoveAll(dir) }()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			changes, err := watch(ctx, dir)
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644), ShouldBeNil)
+			So(waitChange(changes), ShouldBeTrue)
+
+			So(os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b")), ShouldBeNil)
+			So(waitChange(changes), ShouldBeTrue)
+
+			So(os.Remove(filepath.Join(dir, "b")), ShouldBeNil)
+			So(waitChange(changes), ShouldBeTrue)
+
+			cancel()
+			for range changes {
+			}
+
+			_, err = watch(context.Background(), filepath.Join(dir, "missing"))
+			So(err, ShouldNotBeNil)
+		})
+	}
+}
+
+func TestWatchBusyDirectory(t *testing.T) {
+	Convey("Report the change while a sibling file is written continuously", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-fswatch-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		changes, err := Watch(ctx, dir)
+		So(err, ShouldBeNil)
+
+		log, err := os.Create(filepath.Join(dir, "app.log"))
+		So(err, ShouldBeNil)
+		defer func() { _ = log.Close() }()
+
+		// The log is written faster than the settle time until the change is reported
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(settleTime / 5)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					_, _ = log.WriteString("line\n")
+				}
+			}
+		}()
+		defer close(done)
+
+		So(ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644), ShouldBeNil)
+		select {
+		case <-changes:
+		case <-time.After(maxLatency + time.Second):
+			So("the change is not reported", ShouldBeEmpty)
+		}
+	})
+}
@@ -0,0 +1,22 @@
+// Package maputil has the shared helpers to build the nested config maps, used by the root package
+// and the layers.
+package maputil
+
+// Build sets the value in the nested maps of the path, the missing maps are created. a nil map is
+// created too, and the map is returned
+func Build(m map[string]interface{}, v interface{}, k ...string) map[string]interface{} {
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+
+	switch len(k) {
+	case 0:
+		return m
+	case 1:
+		m[k[0]] = v
+		return m
+	}
+	d, _ := m[k[0]].(map[string]interface{})
+	m[k[0]] = Build(d, v, k[1:]...)
+	return m
+}
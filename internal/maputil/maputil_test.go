@@ -0,0 +1,24 @@
+package maputil
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBuild(t *testing.T) {
+	Convey("Build the nested maps", t, func() {
+		m := Build(nil, 1, "a", "b")
+		m = Build(m, 2, "a", "c")
+		m = Build(m, 3, "d")
+		So(m, ShouldResemble, map[string]interface{}{
+			"a": map[string]interface{}{"b": 1, "c": 2},
+			"d": 3,
+		})
+
+		// A value is replaced by a map
+		m = Build(m, 4, "d", "e")
+		So(m["d"], ShouldResemble, map[string]interface{}{"e": 4})
+		So(Build(nil, 1), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,343 @@
+// Package archivelayer is a layer to load the config files from a tar, tar.gz or zip archive
+package archivelayer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/goraz/onion"
+	"github.com/skarademir/naturalsort"
+)
+
+const defaultMaxSize = 64 << 20
+
+var (
+	// ErrDigestMismatch is returned when the archive digest is not the expected digest
+	ErrDigestMismatch = errors.New("archive digest mismatch")
+	// ErrTooLarge is returned when the extracted files are larger than the MaxSize
+	ErrTooLarge = errors.New("the extracted archive is too large")
+	// ErrArchiveTooLarge is returned when the archive is larger than the MaxArchiveSize
+	ErrArchiveTooLarge = errors.New("the archive is too large")
+)
+
+// Options is the archive layer options
+type Options struct {
+	// Digest is the expected digest of the archive (as it is read, before the decryption), in the
+	// algorithm:hex format, sha256 and sha512 are supported. a hex without algorithm is sha256.
+	// empty digest means no check
+	Digest string
+	// Cipher is used to decrypt the archive before the extraction, nil is accepted as plain
+	Cipher onion.Cipher
+	// MaxSize is the maximum total size of the extracted files, to stop the archive bombs. default
+	// is 64 MiB
+	MaxSize int64
+	// MaxArchiveSize is the maximum size of the archive itself (the stream, the file or the http
+	// body), default is 64 MiB
+	MaxArchiveSize int64
+}
+
+func (opt *Options) maxSize() int64 {
+	if opt.MaxSize <= 0 {
+		return defaultMaxSize
+	}
+	return opt.MaxSize
+}
+
+func (opt *Options) maxArchiveSize() int64 {
+	if opt.MaxArchiveSize <= 0 {
+		return defaultMaxSize
+	}
+	return opt.MaxArchiveSize
+}
+
+// streamLayer is the archive layer from a stream, it can not be reloaded
+type streamLayer struct {
+	data map[string]interface{}
+}
+
+func (sl *streamLayer) Load() map[string]interface{} {
+	return sl.data
+}
+
+func (sl *streamLayer) Watch() <-chan map[string]interface{} {
+	return nil
+}
+
+type archiveLayer struct {
+	open func(context.Context) (io.ReadCloser, error)
+	opt  Options
+
+	lock   sync.RWMutex
+	data   map[string]interface{}
+	sender *onion.Sender
+}
+
+func (al *archiveLayer) Load() map[string]interface{} {
+	al.lock.RLock()
+	defer al.lock.RUnlock()
+
+	return al.data
+}
+
+func (al *archiveLayer) Watch() <-chan map[string]interface{} {
+	return al.sender.Watch()
+}
+
+// ReloadLayer reads the archive again
+func (al *archiveLayer) ReloadLayer(ctx context.Context) error {
+	data, err := al.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	al.lock.Lock()
+	defer al.lock.Unlock()
+
+	al.data = data
+	al.sender.Send(data)
+	return nil
+}
+
+func (al *archiveLayer) read(ctx context.Context) (map[string]interface{}, error) {
+	r, err := al.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	return load(ctx, r, al.opt)
+}
+
+func verify(b []byte, digest string) error {
+	if digest == "" {
+		return nil
+	}
+
+	algorithm, sum := "sha256", digest
+	if parts := strings.SplitN(digest, ":", 2); len(parts) == 2 {
+		algorithm, sum = strings.ToLower(parts[0]), parts[1]
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("digest algorithm %q is not supported", algorithm)
+	}
+	_, _ = h.Write(b)
+
+	if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// readLimited reads the file, the remaining is the size left for the rest of the files
+func readLimited(r io.Reader, remaining *int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, *remaining+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > *remaining {
+		return nil, ErrTooLarge
+	}
+	*remaining -= int64(len(b))
+	return b, nil
+}
+
+// extract returns the regular files in the archive, the format is detected from the content. the
+// total size of the files is limited to the maxSize
+func extract(b []byte, maxSize int64) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	remaining := maxSize
+
+	if bytes.HasPrefix(b, []byte("PK\x03\x04")) || bytes.HasPrefix(b, []byte("PK\x05\x06")) {
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			content, err := readLimited(rc, &remaining)
+			_ = rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			files[f.Name] = content
+		}
+		return files, nil
+	}
+
+	var r io.Reader = bytes.NewReader(b)
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = gr.Close() }()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := readLimited(tr, &remaining)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = content
+	}
+}
+
+func load(ctx context.Context, r io.Reader, opt Options) (map[string]interface{}, error) {
+	limit := opt.maxArchiveSize()
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrArchiveTooLarge
+	}
+	if err := verify(b, opt.Digest); err != nil {
+		return nil, err
+	}
+	if opt.Cipher != nil {
+		if b, err = opt.Cipher.Decrypt(bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := extract(b, opt.maxSize())
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range files {
+		ext := strings.TrimPrefix(path.Ext(name), ".")
+		if ext != "" && onion.GetDecoder(ext) != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Sort(naturalsort.NaturalSort(names))
+
+	layersData := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		l, err := onion.NewStreamLayerContext(ctx, bytes.NewReader(files[name]), strings.TrimPrefix(path.Ext(name), "."), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		layersData = append(layersData, l.Load())
+	}
+
+	return onion.NewMapLayer(layersData...).Load(), nil
+}
+
+func newArchiveLayer(ctx context.Context, open func(context.Context) (io.ReadCloser, error), opt Options) (onion.Layer, error) {
+	al := &archiveLayer{
+		open:   open,
+		opt:    opt,
+		sender: onion.NewSender(ctx),
+	}
+
+	var err error
+	if al.data, err = al.read(ctx); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// NewArchiveLayerContext creates a layer from a tar, tar.gz or zip archive in the stream. all the
+// files with a registered format are decoded and merged in the natural sort order of their path.
+// the stream is read once, so the layer is not a onion.Reloader
+func NewArchiveLayerContext(ctx context.Context, r io.Reader, opt Options) (onion.Layer, error) {
+	data, err := load(ctx, r, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamLayer{data: data}, nil
+}
+
+// NewArchiveLayer creates a new archive layer from a stream, see NewArchiveLayerContext
+func NewArchiveLayer(r io.Reader, opt Options) (onion.Layer, error) {
+	return NewArchiveLayerContext(context.Background(), r, opt)
+}
+
+// NewArchiveFileLayerContext creates a layer from an archive file, see NewArchiveLayerContext. the
+// layer implements the onion.Reloader to read the file again
+func NewArchiveFileLayerContext(ctx context.Context, path string, opt Options) (onion.Layer, error) {
+	return newArchiveLayer(ctx, func(context.Context) (io.ReadCloser, error) {
+		return os.Open(path)
+	}, opt)
+}
+
+// NewArchiveFileLayer creates a new archive layer from a file, see NewArchiveFileLayerContext
+func NewArchiveFileLayer(path string, opt Options) (onion.Layer, error) {
+	return NewArchiveFileLayerContext(context.Background(), path, opt)
+}
+
+// NewHTTPArchiveLayerContext creates a layer from an archive downloaded from the url, see
+// NewArchiveLayerContext. a nil client means the http.DefaultClient. the layer implements the
+// onion.Reloader to download the archive again
+func NewHTTPArchiveLayerContext(ctx context.Context, url string, client *http.Client, opt Options) (onion.Layer, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return newArchiveLayer(ctx, func(ctx context.Context) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %q", resp.Status)
+		}
+		return resp.Body, nil
+	}, opt)
+}
+
+// NewHTTPArchiveLayer creates a new archive layer from an url, see NewHTTPArchiveLayerContext
+func NewHTTPArchiveLayer(url string, client *http.Client, opt Options) (onion.Layer, error) {
+	return NewHTTPArchiveLayerContext(context.Background(), url, client, opt)
+}
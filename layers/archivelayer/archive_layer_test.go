@@ -0,0 +1,162 @@
+package archivelayer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var files = []struct {
+	name, content string
+}{
+	{"conf/10-last.json", `{"version": 10, "last": true}`},
+	{"conf/2-first.json", `{"version": 2, "first": true}`},
+	{"README.md", "not a config"},
+	{"app.json", `{"app": {"port": 8080}}`},
+}
+
+func tarGz(compress bool) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	So(tw.WriteHeader(&tar.Header{Name: "conf/", Typeflag: tar.TypeDir, Mode: 0755}), ShouldBeNil)
+	for _, f := range files {
+		So(tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.content))}), ShouldBeNil)
+		_, err := tw.Write([]byte(f.content))
+		So(err, ShouldBeNil)
+	}
+	So(tw.Close(), ShouldBeNil)
+	if gw != nil {
+		So(gw.Close(), ShouldBeNil)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive() []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		So(err, ShouldBeNil)
+		_, err = w.Write([]byte(f.content))
+		So(err, ShouldBeNil)
+	}
+	So(zw.Close(), ShouldBeNil)
+	return buf.Bytes()
+}
+
+type base64Cipher struct{}
+
+func (base64Cipher) Decrypt(r io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+}
+
+func checkLayer(l onion.Layer) {
+	o := onion.New(l)
+	So(o.GetInt("version"), ShouldEqual, 10)
+	So(o.GetBool("first"), ShouldBeTrue)
+	So(o.GetBool("last"), ShouldBeTrue)
+	So(o.GetInt("app.port"), ShouldEqual, 8080)
+}
+
+func TestNewArchiveLayer(t *testing.T) {
+	Convey("Load the archives", t, func() {
+		for _, b := range [][]byte{tarGz(true), tarGz(false), zipArchive()} {
+			sum := sha256.Sum256(b)
+
+			l, err := NewArchiveLayer(bytes.NewReader(b), Options{Digest: hex.EncodeToString(sum[:])})
+			So(err, ShouldBeNil)
+			checkLayer(l)
+
+			_, err = NewArchiveLayer(bytes.NewReader(b), Options{Digest: "sha256:" + hex.EncodeToString(sum[:4])})
+			So(err, ShouldEqual, ErrDigestMismatch)
+			_, err = NewArchiveLayer(bytes.NewReader(b), Options{Digest: "md5:00"})
+			So(err, ShouldNotBeNil)
+
+			_, ok := l.(onion.Reloader)
+			So(ok, ShouldBeFalse)
+
+			_, err = NewArchiveLayer(bytes.NewReader(b), Options{MaxSize: 64})
+			So(err, ShouldEqual, ErrTooLarge)
+			_, err = NewArchiveLayer(bytes.NewReader(b), Options{MaxArchiveSize: int64(len(b)) - 1})
+			So(err, ShouldEqual, ErrArchiveTooLarge)
+			_, err = NewArchiveLayer(bytes.NewReader(b), Options{MaxArchiveSize: int64(len(b))})
+			So(err, ShouldBeNil)
+		}
+	})
+
+	Convey("Decrypt the archive", t, func() {
+		encrypted := []byte(base64.StdEncoding.EncodeToString(tarGz(true)))
+		l, err := NewArchiveLayer(bytes.NewReader(encrypted), Options{Cipher: base64Cipher{}})
+		So(err, ShouldBeNil)
+		checkLayer(l)
+
+		_, err = NewArchiveLayer(bytes.NewReader([]byte("invalid")), Options{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Load and reload from a file", t, func() {
+		dir, err := ioutil.TempDir("", "onion-archive-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		name := filepath.Join(dir, "config.tar.gz")
+		So(ioutil.WriteFile(name, tarGz(true), 0644), ShouldBeNil)
+		l, err := NewArchiveFileLayer(name, Options{})
+		So(err, ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetInt("version"), ShouldEqual, 10)
+
+		files = append(files, struct{ name, content string }{"conf/20-override.json", `{"version": 20}`})
+		defer func() { files = files[:len(files)-1] }()
+		So(ioutil.WriteFile(name, zipArchive(), 0644), ShouldBeNil)
+
+		watch := o.ReloadWatch()
+		res := o.Reload(context.Background())
+		So(res[0].Err, ShouldBeNil)
+		<-watch
+		So(o.GetInt("version"), ShouldEqual, 20)
+
+		_, err = NewArchiveFileLayer(filepath.Join(dir, "unknown.zip"), Options{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Download the archive", t, func() {
+		b := zipArchive()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/config.zip" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(b)
+		}))
+		defer srv.Close()
+
+		l, err := NewHTTPArchiveLayer(srv.URL+"/config.zip", nil, Options{})
+		So(err, ShouldBeNil)
+		checkLayer(l)
+
+		_, err = NewHTTPArchiveLayer(srv.URL+"/unknown.zip", srv.Client(), Options{})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,23 @@
+package archivelayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	// The archive is a file with the path or downloaded from the url
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := Options{
+			Digest:         spec.Options.GetString("digest"),
+			Cipher:         spec.Cipher,
+			MaxSize:        spec.Options.GetInt64("max_size"),
+			MaxArchiveSize: spec.Options.GetInt64("max_archive_size"),
+		}
+		if url := spec.Options.GetString("url"); url != "" {
+			return NewHTTPArchiveLayerContext(ctx, url, nil, opt)
+		}
+		return NewArchiveFileLayerContext(ctx, spec.Options.GetString("path"), opt)
+	}, "archive")
+}
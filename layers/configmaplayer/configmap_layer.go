@@ -0,0 +1,161 @@
+// Package configmaplayer is a layer for the Kubernetes ConfigMap and Secret mounted volumes.
+//
+// Kubernetes writes the projected volume in a timestamped directory and then atomically swaps the
+// `..data` symlink to point to it, the files in the volume are symlinks into `..data`. this layer
+// reads the files from the directory behind the `..data` symlink, so all the files are from the
+// same version, and it reloads when the symlink is swapped. if there is no `..data` in the
+// directory, the directory itself is used and any change in the files reloads the layer. the
+// directory is watched with fsnotify, or polled if it is not available.
+package configmaplayer
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/fswatch"
+	"github.com/skarademir/naturalsort"
+)
+
+const dataDir = "..data"
+
+type configMapLayer struct {
+	lock   sync.RWMutex
+	dir    string
+	decode bool
+	cipher onion.Cipher
+	data   map[string]interface{}
+	sender *onion.Sender
+}
+
+func (cl *configMapLayer) Load() map[string]interface{} {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+
+	return cl.data
+}
+
+func (cl *configMapLayer) Watch() <-chan map[string]interface{} {
+	return cl.sender.Watch()
+}
+
+// ReloadLayer reads the volume again, the new data is sent to the watch channel only if it is
+// changed. the files are read from behind the ..data symlink, so the half written versions (before
+// the swap) are not seen
+func (cl *configMapLayer) ReloadLayer(ctx context.Context) error {
+	data, err := cl.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if reflect.DeepEqual(data, cl.data) {
+		return nil
+	}
+	cl.data = data
+	cl.sender.Send(data)
+	return nil
+}
+
+// root returns the directory with the actual files
+func (cl *configMapLayer) root() (string, error) {
+	root, err := filepath.EvalSymlinks(filepath.Join(cl.dir, dataDir))
+	if os.IsNotExist(err) {
+		return cl.dir, nil
+	}
+	return root, err
+}
+
+func (cl *configMapLayer) read(ctx context.Context) (map[string]interface{}, error) {
+	root, err := cl.root()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		// The ..data and the timestamped directories are hidden
+		if strings.HasPrefix(e.Name(), "..") || e.IsDir() {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Sort(naturalsort.NaturalSort(names))
+
+	data := make(map[string]interface{})
+	var decoded []map[string]interface{}
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		ext := strings.TrimPrefix(filepath.Ext(name), ".")
+		if cl.decode && ext != "" && onion.GetDecoder(ext) != nil {
+			l, err := onion.NewFileLayerContext(ctx, path, cl.cipher)
+			if err != nil {
+				return nil, err
+			}
+			decoded = append(decoded, l.Load())
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data[name] = string(b)
+	}
+
+	if len(decoded) == 0 {
+		return data, nil
+	}
+	return onion.NewMapLayer(append(decoded, data)...).Load(), nil
+}
+
+// NewConfigMapLayerContext creates a layer from a mounted ConfigMap or Secret volume. each file is
+// a key with the file content as the value, if the decode is true the files with a registered
+// extension (like app.yaml) are decoded (and decrypted with the cipher if it is not nil) and
+// merged in the natural sort order. the layer watches the directory until the context is done.
+func NewConfigMapLayerContext(ctx context.Context, dir string, decode bool, c onion.Cipher) (onion.Layer, error) {
+	cl := &configMapLayer{
+		dir:    filepath.Clean(dir),
+		decode: decode,
+		cipher: c,
+		sender: onion.NewSender(ctx),
+	}
+
+	var err error
+	if cl.data, err = cl.read(ctx); err != nil {
+		return nil, err
+	}
+
+	changes, err := fswatch.Watch(ctx, cl.dir)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for range changes {
+			if err := cl.ReloadLayer(ctx); err != nil {
+				log.Println("error:", err) // Better log support
+			}
+		}
+	}()
+
+	return cl, nil
+}
+
+// NewConfigMapLayer creates a new ConfigMap layer, see NewConfigMapLayerContext
+func NewConfigMapLayer(dir string, decode bool, c onion.Cipher) (onion.Layer, error) {
+	return NewConfigMapLayerContext(context.Background(), dir, decode, c)
+}
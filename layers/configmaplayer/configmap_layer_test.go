@@ -0,0 +1,105 @@
+package configmaplayer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeVersion mimics the kubelet, writes the files in a new timestamped directory and swaps the
+// ..data symlink atomically
+func writeVersion(dir, version string, files map[string]string) error {
+	vd := filepath.Join(dir, version)
+	if err := os.Mkdir(vd, 0755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(vd, name), []byte(content), 0644); err != nil {
+			return err
+		}
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join(dataDir, name), link); err != nil {
+				return err
+			}
+		}
+	}
+
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(version, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, dataDir))
+}
+
+func TestNewConfigMapLayerContext(t *testing.T) {
+	Convey("Test projected volume layer", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-configmap-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		So(writeVersion(dir, "..2021_01_01_00_00_00.1", map[string]string{
+			"log_level": "debug",
+			"app.json":  `{"db": {"host": "h1"}}`,
+		}), ShouldBeNil)
+
+		Convey("each file is a key", func() {
+			l, err := NewConfigMapLayerContext(ctx, dir, false, nil)
+			So(err, ShouldBeNil)
+			o := onion.NewContext(ctx, l)
+			So(o.GetString("log_level"), ShouldEqual, "debug")
+			_, ok := o.Get("..data")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("decode and reload on symlink swap", func() {
+			l, err := NewConfigMapLayerContext(ctx, dir, true, nil)
+			So(err, ShouldBeNil)
+			o := onion.NewContext(ctx, l)
+			So(o.GetString("db.host"), ShouldEqual, "h1")
+			So(o.GetString("log_level"), ShouldEqual, "debug")
+
+			watch := o.ReloadWatch()
+			So(writeVersion(dir, "..2021_01_01_00_00_00.2", map[string]string{
+				"log_level": "info",
+				"app.json":  `{"db": {"host": "h2"}}`,
+			}), ShouldBeNil)
+			<-watch
+			So(o.GetString("db.host"), ShouldEqual, "h2")
+			So(o.GetString("log_level"), ShouldEqual, "info")
+		})
+	})
+
+	Convey("Test plain directory", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-configmap-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		So(ioutil.WriteFile(filepath.Join(dir, "key"), []byte("v1"), 0644), ShouldBeNil)
+		l, err := NewConfigMapLayerContext(ctx, dir, false, nil)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetString("key"), ShouldEqual, "v1")
+
+		watch := o.ReloadWatch()
+		// Write and rename, so the reload never sees a half written file
+		So(ioutil.WriteFile(filepath.Join(dir, "..other"), []byte("v2"), 0644), ShouldBeNil)
+		So(os.Rename(filepath.Join(dir, "..other"), filepath.Join(dir, "other")), ShouldBeNil)
+		<-watch
+		So(o.GetString("other"), ShouldEqual, "v2")
+
+		_, err = NewConfigMapLayerContext(ctx, filepath.Join(dir, "missing"), false, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,13 @@
+package configmaplayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		return NewConfigMapLayerContext(ctx, spec.Options.GetString("path"), spec.Options.GetBool("decode"), spec.Cipher)
+	}, "configmap")
+}
@@ -1,32 +1,116 @@
 package directorylayer
 
 import (
+	"context"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"sync"
 
 	"github.com/goraz/onion"
 	"github.com/skarademir/naturalsort"
 )
 
-// NewDirectoryLayer return a new directory layer.
+type directoryLayer struct {
+	lock   sync.RWMutex
+	load   func() (map[string]interface{}, error)
+	data   map[string]interface{}
+	sender *onion.Sender
+}
+
+func (dl *directoryLayer) Load() map[string]interface{} {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.data
+}
+
+func (dl *directoryLayer) Watch() <-chan map[string]interface{} {
+	return dl.sender.Watch()
+}
+
+// ReloadLayer scans the directory again, so the new and removed files are also handled
+func (dl *directoryLayer) ReloadLayer(_ context.Context) error {
+	data, err := dl.load()
+	if err != nil {
+		return err
+	}
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.data = data
+	dl.sender.Send(data)
+	return nil
+}
+
+func newDirectoryLayer(ctx context.Context, load func() (map[string]interface{}, error)) (onion.Layer, error) {
+	data, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &directoryLayer{
+		load:   load,
+		data:   data,
+		sender: onion.NewSender(ctx),
+	}, nil
+}
+
+// NewDirectoryLayerContext return a new directory layer.
 // This layer search in a directory for all files with filesExtension extension
-// and will use each of them as a file layer
-func NewDirectoryLayer(directory, filesExtension string) (onion.Layer, error) {
+// and will use each of them as a file layer. the layer implements the onion.Reloader
+// to scan the directory again, the reloads are sent until the context is done
+func NewDirectoryLayerContext(ctx context.Context, directory, filesExtension string) (onion.Layer, error) {
 	if directory[len(directory)-1:] != string(os.PathSeparator) {
 		directory += string(os.PathSeparator)
 	}
 
-	fileNames := getFilesInOrder(directory, filesExtension)
+	return newDirectoryLayer(ctx, func() (map[string]interface{}, error) {
+		return loadFiles(getFilesInOrder(directory, filesExtension), func(name string) (onion.Layer, error) {
+			return onion.NewFileLayer(name, nil)
+		})
+	})
+}
+
+// NewDirectoryLayer return a new directory layer, see NewDirectoryLayerContext
+func NewDirectoryLayer(directory, filesExtension string) (onion.Layer, error) {
+	return NewDirectoryLayerContext(context.Background(), directory, filesExtension)
+}
+
+// NewFSDirectoryLayerContext is the NewDirectoryLayerContext for a file system, like embed.FS,
+// zip.Reader or fstest.MapFS. the directory is in the fs.FS format (slash separated, "." for the
+// root)
+func NewFSDirectoryLayerContext(ctx context.Context, fsys fs.FS, directory, filesExtension string) (onion.Layer, error) {
+	return newDirectoryLayer(ctx, func() (map[string]interface{}, error) {
+		fileNames, err := fs.Glob(fsys, path.Join(directory, "*."+filesExtension))
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(naturalsort.NaturalSort(fileNames))
+
+		return loadFiles(fileNames, func(name string) (onion.Layer, error) {
+			return onion.NewFSFileLayer(fsys, name, nil)
+		})
+	})
+}
+
+// NewFSDirectoryLayer is the NewDirectoryLayer for a file system, see NewFSDirectoryLayerContext
+func NewFSDirectoryLayer(fsys fs.FS, directory, filesExtension string) (onion.Layer, error) {
+	return NewFSDirectoryLayerContext(context.Background(), fsys, directory, filesExtension)
+}
 
+func loadFiles(fileNames []string, open func(string) (onion.Layer, error)) (map[string]interface{}, error) {
 	if len(fileNames) == 0 {
-		return onion.NewMapLayer(nil), nil
+		return nil, nil
 	}
 
 	layersData := make([]map[string]interface{}, 0)
 
 	for _, fileName := range fileNames {
-		layer, err := onion.NewFileLayer(fileName, nil)
+		layer, err := open(fileName)
 
 		if err != nil {
 			return nil, err
@@ -35,7 +119,7 @@ func NewDirectoryLayer(directory, filesExtension string) (onion.Layer, error) {
 		layersData = append(layersData, layer.Load())
 	}
 
-	return onion.NewMapLayer(layersData...), nil
+	return onion.NewMapLayer(layersData...).Load(), nil
 }
 
 func getFilesInOrder(directory, filesExtension string) []string {
@@ -1,10 +1,13 @@
 package directorylayer
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/goraz/onion"
 	. "github.com/smartystreets/goconvey/convey"
@@ -53,3 +56,71 @@ func TestNewDirectoryLayer(t *testing.T) {
 		os.RemoveAll(directoryName)
 	})
 }
+
+func TestDirectoryLayerReload(t *testing.T) {
+	Convey("Reload the directory layer", t, func() {
+		directoryName, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(directoryName) }()
+
+		So(ioutil.WriteFile(directoryName+"/test0.json", []byte(testFile1), 0644), ShouldBeNil)
+		directoryLayer, err := NewDirectoryLayer(directoryName, "json")
+		So(err, ShouldBeNil)
+		o := onion.New(directoryLayer)
+		So(o.GetInt("number"), ShouldEqual, 100)
+
+		So(ioutil.WriteFile(directoryName+"/test1.json", []byte(testFile2), 0644), ShouldBeNil)
+		watch := o.ReloadWatch()
+		res := o.Reload(context.Background())
+		So(res, ShouldHaveLength, 1)
+		So(res[0].Err, ShouldBeNil)
+		<-watch
+		So(o.GetInt("number"), ShouldEqual, 101)
+
+		So(os.Remove(directoryName+"/test0.json"), ShouldBeNil)
+		So(ioutil.WriteFile(directoryName+"/test1.json", []byte("invalid"), 0644), ShouldBeNil)
+		res = o.Reload(context.Background())
+		So(res[0].Err, ShouldNotBeNil)
+		So(o.GetString("string-not-to-override"), ShouldEqual, "pippo")
+	})
+}
+
+func TestNewFSDirectoryLayer(t *testing.T) {
+	Convey("Test directory layer from fs.FS", t, func() {
+		fsys := fstest.MapFS{
+			"conf/test10.json": &fstest.MapFile{Data: []byte(testFile2)},
+			"conf/test2.json":  &fstest.MapFile{Data: []byte(testFile1)},
+			"conf/other.yaml":  &fstest.MapFile{Data: []byte("invalid: [")},
+		}
+
+		l, err := NewFSDirectoryLayer(fsys, "conf", "json")
+		So(err, ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetString("string-not-to-override"), ShouldEqual, "pippo")
+		So(o.GetInt("number"), ShouldEqual, 101)
+
+		l, err = NewFSDirectoryLayer(fsys, ".", "json")
+		So(err, ShouldBeNil)
+		So(l.Load(), ShouldBeNil)
+
+		fsys["conf/test3.json"] = &fstest.MapFile{Data: []byte("invalid")}
+		_, err = NewFSDirectoryLayer(fsys, "conf", "json")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDirectoryLayerSpec(t *testing.T) {
+	Convey("Directory layer from the spec", t, func() {
+		directoryName, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(directoryName) }()
+
+		// The extension option, only the files with the extension are loaded
+		So(ioutil.WriteFile(directoryName+"/test0.json", []byte(testFile1), 0644), ShouldBeNil)
+		spec := `{"layers": [{"type": "directory", "path": "` + directoryName + `", "extension": "yaml"}]}`
+		o, err := onion.FromSpec(strings.NewReader(spec), "json", nil)
+		So(err, ShouldBeNil)
+		_, ok := o.Get("number")
+		So(ok, ShouldBeFalse)
+	})
+}
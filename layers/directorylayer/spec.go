@@ -0,0 +1,13 @@
+package directorylayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		return NewDirectoryLayerContext(ctx, spec.Options.GetString("path"), spec.Options.GetStringDefault("extension", "json"))
+	}, "directory")
+}
@@ -1,18 +1,23 @@
 package directorywatchlayer
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/fswatch"
 	"github.com/skarademir/naturalsort"
 )
 
@@ -21,19 +26,49 @@ var (
 	ErrReloadNotSupported = errors.New("layer doesn't support reload")
 )
 
-// NewDirectoryWatchLayerContext watch for changes of existing files TODO: Watch new files
+func checkDir(dir string) error {
+	if fs, err := os.Stat(dir); nil != err {
+		return err
+	} else if !fs.IsDir() {
+		return ErrNotDir
+	}
+	return nil
+}
+
+// NewDirectoryWatchLayerContext watch for changes of existing files, each file is a separate layer.
+// for watching the new and removed files, use the NewMergedLayerContext
 func NewDirectoryWatchLayerContext(
 	ctx context.Context,
 	dir string,
 	cipher onion.Cipher,
 	extensions ...string,
+) ([]onion.Layer, error) {
+	return newDirectoryWatchLayers(ctx, dir, cipher, fswatch.Watch, extensions...)
+}
+
+// NewDirectoryPollLayerContext is the NewDirectoryWatchLayerContext with polling at every interval
+// instead of fsnotify, for the file systems like NFS
+func NewDirectoryPollLayerContext(
+	ctx context.Context,
+	dir string,
+	cipher onion.Cipher,
+	interval time.Duration,
+	extensions ...string,
+) ([]onion.Layer, error) {
+	return newDirectoryWatchLayers(ctx, dir, cipher, fswatch.Poll(interval), extensions...)
+}
+
+func newDirectoryWatchLayers(
+	ctx context.Context,
+	dir string,
+	cipher onion.Cipher,
+	watch fswatch.WatchFunc,
+	extensions ...string,
 ) ([]onion.Layer, error) {
 	dir = filepath.Clean(dir)
 
-	if fs, err := os.Stat(dir); nil != err {
+	if err := checkDir(dir); err != nil {
 		return nil, err
-	} else if !fs.IsDir() {
-		return nil, ErrNotDir
 	}
 
 	files, errList := directoryListByExtensions(dir, extensions...)
@@ -42,59 +77,198 @@ func NewDirectoryWatchLayerContext(
 	}
 
 	pathToLayerIndex := make(map[string]int)
+	hashes := make(map[string][sha256.Size]byte)
 	layers := make([]onion.Layer, len(files))
 	for k, path := range files {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
 		if l, err := onion.NewFileLayerContext(ctx, path, cipher); nil == err {
 			layers[k] = l
 			pathToLayerIndex[path] = k
+			hashes[path] = sha256.Sum256(b)
 		} else {
 			return nil, err
 		}
 	}
 
-	watcher, errInit := fsnotify.NewWatcher()
-	if nil != errInit {
-		return nil, errInit
+	changes, errWatch := watch(ctx, dir)
+	if nil != errWatch {
+		return nil, errWatch
 	}
 
-	if err := watcher.Add(dir); nil != err {
-		_ = watcher.Close()
+	go func() {
+		for range changes {
+			for path, k := range pathToLayerIndex {
+				b, err := ioutil.ReadFile(path)
+				if err != nil {
+					// Removed files keep the last data
+					continue
+				}
+				if sum := sha256.Sum256(b); sum != hashes[path] {
+					hashes[path] = sum
+					if err := reloadLayer(ctx, layers[k], path, b); err != nil {
+						log.Println("error:", err) // Better log support
+					}
+				}
+			}
+		}
+	}()
+
+	return layers, nil
+}
 
-		return nil, err
+func NewDirectoryWatchLayer(dir string, cipher onion.Cipher, extensions ...string) ([]onion.Layer, error) {
+	return NewDirectoryWatchLayerContext(context.Background(), dir, cipher, extensions...)
+}
+
+// NewDirectoryPollLayer see NewDirectoryPollLayerContext
+func NewDirectoryPollLayer(dir string, cipher onion.Cipher, interval time.Duration, extensions ...string) ([]onion.Layer, error) {
+	return NewDirectoryPollLayerContext(context.Background(), dir, cipher, interval, extensions...)
+}
+
+type mergedLayer struct {
+	lock       sync.RWMutex
+	dir        string
+	cipher     onion.Cipher
+	extensions []string
+	hash       [sha256.Size]byte
+	data       map[string]interface{}
+	sender     *onion.Sender
+}
+
+func (ml *mergedLayer) Load() map[string]interface{} {
+	ml.lock.RLock()
+	defer ml.lock.RUnlock()
+
+	return ml.data
+}
+
+func (ml *mergedLayer) Watch() <-chan map[string]interface{} {
+	return ml.sender.Watch()
+}
+
+// read loads all the files in the natural sort order, the hash is the hash of all the file names
+// and their content
+func (ml *mergedLayer) read(ctx context.Context) (map[string]interface{}, [sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	files, err := directoryListByExtensions(ml.dir, ml.extensions...)
+	if err != nil {
+		return nil, sum, err
 	}
 
-	go func() {
-		defer func() { _ = watcher.Close() }()
+	h := sha256.New()
+	contents := make([][]byte, 0, len(files))
+	names := make([]string, 0, len(files))
+	for _, path := range files {
+		if st, err := os.Stat(path); err == nil && st.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// Removed after the list
+			continue
+		}
+		if err != nil {
+			return nil, sum, err
+		}
+		_, _ = fmt.Fprintf(h, "%s:%d:", path, len(b))
+		_, _ = h.Write(b)
+		contents = append(contents, b)
+		names = append(names, path)
+	}
+	copy(sum[:], h.Sum(nil))
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
+	if sum == ml.hash && ml.data != nil {
+		return ml.data, sum, nil
+	}
 
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
+	layersData := make([]map[string]interface{}, 0, len(names))
+	for i, path := range names {
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		l, err := onion.NewStreamLayerContext(ctx, bytes.NewReader(contents[i]), ext, ml.cipher)
+		if err != nil {
+			return nil, sum, fmt.Errorf("%s: %w", path, err)
+		}
+		layersData = append(layersData, l.Load())
+	}
 
-				if fsnotify.Write == event.Op&fsnotify.Write {
-					<-time.After(time.Second) // sometime it triggers before the complete write TODO: find a solution (not hack)
+	return onion.NewMapLayer(layersData...).Load(), sum, nil
+}
 
-					_ = reloadLayer(ctx, layers[pathToLayerIndex[event.Name]], event.Name)
-				}
+// ReloadLayer reads the directory again, the watch channel receives the new data only if
+// something is changed
+func (ml *mergedLayer) ReloadLayer(ctx context.Context) error {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
 
-			case _, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
+	data, sum, err := ml.read(ctx)
+	if err != nil {
+		return err
+	}
+	if sum == ml.hash {
+		return nil
+	}
+	ml.hash, ml.data = sum, data
+	ml.sender.Send(data)
+	return nil
+}
+
+// NewMergedLayerContext creates a single layer from all the files with the extensions in the
+// directory, merged in the natural sort order. it watches the directory until the context is done
+// and handles the new, removed and renamed files. the files are compared by content, so the
+// editors that save by rename and multiple events for one write are handled.
+func NewMergedLayerContext(ctx context.Context, dir string, cipher onion.Cipher, extensions ...string) (onion.Layer, error) {
+	return newMergedLayer(ctx, dir, cipher, fswatch.Watch, extensions...)
+}
+
+// NewMergedPollLayerContext is the NewMergedLayerContext with polling at every interval instead of
+// fsnotify, for the file systems like NFS
+func NewMergedPollLayerContext(ctx context.Context, dir string, cipher onion.Cipher, interval time.Duration, extensions ...string) (onion.Layer, error) {
+	return newMergedLayer(ctx, dir, cipher, fswatch.Poll(interval), extensions...)
+}
+
+func newMergedLayer(ctx context.Context, dir string, cipher onion.Cipher, watch fswatch.WatchFunc, extensions ...string) (onion.Layer, error) {
+	dir = filepath.Clean(dir)
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+
+	ml := &mergedLayer{
+		dir:        dir,
+		cipher:     cipher,
+		extensions: extensions,
+		sender:     onion.NewSender(ctx),
+	}
+	var err error
+	if ml.data, ml.hash, err = ml.read(ctx); err != nil {
+		return nil, err
+	}
+
+	changes, err := watch(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for range changes {
+			if err := ml.ReloadLayer(ctx); err != nil {
+				log.Println("error:", err) // Better log support
 			}
 		}
 	}()
 
-	return layers, nil
+	return ml, nil
 }
 
-func NewDirectoryWatchLayer(dir string, cipher onion.Cipher, extensions ...string) ([]onion.Layer, error) {
-	return NewDirectoryWatchLayerContext(context.Background(), dir, cipher, extensions...)
+// NewMergedLayer creates a merged directory layer, see NewMergedLayerContext
+func NewMergedLayer(dir string, cipher onion.Cipher, extensions ...string) (onion.Layer, error) {
+	return NewMergedLayerContext(context.Background(), dir, cipher, extensions...)
+}
+
+// NewMergedPollLayer creates a merged directory layer with polling, see NewMergedPollLayerContext
+func NewMergedPollLayer(dir string, cipher onion.Cipher, interval time.Duration, extensions ...string) (onion.Layer, error) {
+	return NewMergedPollLayerContext(context.Background(), dir, cipher, interval, extensions...)
 }
 
 func directoryListByExtensions(dir string, extensions ...string) ([]string, error) {
@@ -125,19 +299,13 @@ type streamReload interface {
 	Reload(context.Context, io.Reader, string) error
 }
 
-func reloadLayer(ctx context.Context, layer onion.Layer, path string) error {
+func reloadLayer(ctx context.Context, layer onion.Layer, path string, content []byte) error {
 	sl, ok := layer.(streamReload)
 	if !ok {
 		return ErrReloadNotSupported
 	}
 
-	fh, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = fh.Close() }()
-
 	ext := strings.TrimPrefix(filepath.Ext(path), ".")
 
-	return sl.Reload(ctx, fh, ext)
+	return sl.Reload(ctx, bytes.NewReader(content), ext)
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -80,3 +81,100 @@ func getCfgMapFixture(group, index int) map[string]interface{} {
 func cfgKey(index int) string {
 	return fmt.Sprintf("cfgtkey%d", index)
 }
+
+func TestNewMergedLayerContext(t *testing.T) {
+	Convey("Test merged directory layer", t, func() {
+		dir, errMkdir := ioutil.TempDir(os.TempDir(), "onion-merged-*")
+		So(errMkdir, ShouldBeNil)
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		So(writeJson(filepath.Join(dir, "10.json"), map[string]interface{}{"a": 10, "b": 10}), ShouldBeNil)
+		So(writeJson(filepath.Join(dir, "2.json"), map[string]interface{}{"a": 2, "c": 2}), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("x"), 0644), ShouldBeNil)
+
+		l, err := NewMergedLayerContext(ctx, dir, nil, "json")
+		So(err, ShouldBeNil)
+		cfg := onion.NewContext(ctx, l)
+		// Natural sort, 10.json is after 2.json
+		So(cfg.GetInt("a"), ShouldEqual, 10)
+		So(cfg.GetInt("c"), ShouldEqual, 2)
+
+		Convey("new file", func() {
+			watch := cfg.ReloadWatch()
+			So(writeJson(filepath.Join(dir, "20.json"), map[string]interface{}{"a": 20}), ShouldBeNil)
+			<-watch
+			So(cfg.GetInt("a"), ShouldEqual, 20)
+		})
+
+		Convey("removed file", func() {
+			watch := cfg.ReloadWatch()
+			So(os.Remove(filepath.Join(dir, "10.json")), ShouldBeNil)
+			<-watch
+			So(cfg.GetInt("a"), ShouldEqual, 2)
+			So(cfg.GetInt("b"), ShouldEqual, 0)
+		})
+
+		Convey("save by rename", func() {
+			watch := cfg.ReloadWatch()
+			tmp := filepath.Join(dir, ".2.json.swp")
+			So(writeJson(tmp, map[string]interface{}{"c": 3}), ShouldBeNil)
+			So(os.Rename(tmp, filepath.Join(dir, "2.json")), ShouldBeNil)
+			<-watch
+			So(cfg.GetInt("c"), ShouldEqual, 3)
+		})
+
+		Convey("no change, no reload", func() {
+			watch := cfg.ReloadWatch()
+			So(ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("y"), 0644), ShouldBeNil)
+			select {
+			case <-watch:
+				So("reloaded", ShouldBeEmpty)
+			case <-time.After(500 * time.Millisecond):
+			}
+		})
+
+		_, err = NewMergedLayerContext(ctx, filepath.Join(dir, "ignored.txt"), nil)
+		So(err, ShouldEqual, ErrNotDir)
+	})
+}
+
+func TestPollLayers(t *testing.T) {
+	Convey("Test polling directory layers", t, func() {
+		dir, errMkdir := ioutil.TempDir(os.TempDir(), "onion-poll-*")
+		So(errMkdir, ShouldBeNil)
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		So(writeJson(filepath.Join(dir, "1.json"), getCfgMapFixture(1, 0)), ShouldBeNil)
+		ll, err := NewDirectoryPollLayerContext(ctx, dir, nil, 20*time.Millisecond, "json")
+		So(err, ShouldBeNil)
+		l, err := NewMergedPollLayerContext(ctx, dir, nil, 20*time.Millisecond, "json")
+		So(err, ShouldBeNil)
+
+		separate := onion.NewContext(ctx, ll...)
+		merged := onion.NewContext(ctx, l)
+		So(separate.GetInt(cfgKey(0)), ShouldEqual, 100)
+		So(merged.GetInt(cfgKey(0)), ShouldEqual, 100)
+
+		sw, mw := separate.ReloadWatch(), merged.ReloadWatch()
+		So(writeJson(filepath.Join(dir, "1.json"), getCfgMapFixture(2, 0)), ShouldBeNil)
+		<-sw
+		<-mw
+		So(separate.GetInt(cfgKey(0)), ShouldEqual, 200)
+		So(merged.GetInt(cfgKey(0)), ShouldEqual, 200)
+
+		mw = merged.ReloadWatch()
+		So(writeJson(filepath.Join(dir, "2.json"), getCfgMapFixture(3, 1)), ShouldBeNil)
+		<-mw
+		So(merged.GetInt(cfgKey(1)), ShouldEqual, 301)
+	})
+}
@@ -0,0 +1,18 @@
+package directorywatchlayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	// The merged layer, since the spec creates one layer for each type
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		dir, exts := spec.Options.GetString("path"), spec.Options.GetStringSlice("extensions")
+		if interval := spec.Options.GetDuration("poll_interval"); interval > 0 {
+			return NewMergedPollLayerContext(ctx, dir, spec.Cipher, interval, exts...)
+		}
+		return NewMergedLayerContext(ctx, dir, spec.Cipher, exts...)
+	}, "directorywatch")
+}
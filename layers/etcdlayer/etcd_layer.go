@@ -4,28 +4,110 @@ package etcdlayer
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/goraz/onion"
 	goetcd "go.etcd.io/etcd/client"
 )
 
+// ErrConflict is returned on Commit when the key is changed after the last load, the changes are
+// kept and the Commit can be retried after the layer is reloaded
+var ErrConflict = errors.New("the key is changed in etcd")
+
 type streamReload interface {
 	Reload(context.Context, io.Reader, string) error
 }
 
-func getWithContext(ctx context.Context, api goetcd.KeysAPI, key string) (io.Reader, error) {
+type etcdLayer struct {
+	onion.Layer
+
+	api    goetcd.KeysAPI
+	key    string
+	format string
+	cipher onion.Cipher
+
+	// The last loaded node, for the compare and swap
+	lock  sync.Mutex
+	value string
+	index uint64
+
+	changes onion.ChangeSet
+}
+
+func (el *etcdLayer) reload(ctx context.Context, node *goetcd.Node) error {
+	el.lock.Lock()
+	defer el.lock.Unlock()
+
+	if node.ModifiedIndex <= el.index {
+		return nil
+	}
+	el.value, el.index = node.Value, node.ModifiedIndex
+	return el.Layer.(streamReload).Reload(ctx, bytes.NewReader([]byte(node.Value)), el.format)
+}
+
+// Set stages a change in the key, see onion.WritableLayer
+func (el *etcdLayer) Set(key string, value interface{}) error {
+	return el.changes.Set(key, value)
+}
+
+// Delete stages a delete in the key, see onion.WritableLayer
+func (el *etcdLayer) Delete(key string) error {
+	return el.changes.Delete(key)
+}
+
+// Commit applies the changes on the last loaded value and writes it only if the key is not
+// changed since then, otherwise it returns ErrConflict
+func (el *etcdLayer) Commit(ctx context.Context) error {
+	if el.cipher != nil {
+		return onion.ErrReadOnly
+	}
+	enc := onion.GetEncoder(el.format)
+	if enc == nil {
+		return fmt.Errorf("there is no encoder for format %q", el.format)
+	}
+
+	el.lock.Lock()
+	value, index := el.value, el.index
+	el.lock.Unlock()
+
+	data, err := onion.GetDecoder(el.format).Decode(ctx, bytes.NewReader([]byte(value)))
+	if err != nil {
+		return err
+	}
+
+	return el.changes.Commit(data, func(data map[string]interface{}) error {
+		buf := &bytes.Buffer{}
+		if err := enc.Encode(ctx, buf, data); err != nil {
+			return err
+		}
+		resp, err := el.api.Set(ctx, el.key, buf.String(), &goetcd.SetOptions{PrevIndex: index})
+		if err != nil {
+			var e goetcd.Error
+			if errors.As(err, &e) && e.Code == goetcd.ErrorCodeTestFailed {
+				return fmt.Errorf("%w: %v", ErrConflict, err)
+			}
+			return err
+		}
+
+		return el.reload(ctx, resp.Node)
+	})
+}
+
+func getWithContext(ctx context.Context, api goetcd.KeysAPI, key string) (*goetcd.Node, error) {
 	resp, err := api.Get(ctx, key, nil)
 	if err != nil {
 		return nil, err
 	}
-	return bytes.NewReader([]byte(resp.Node.Value)), nil
+	return resp.Node, nil
 }
 
-func watchWithContext(ctx context.Context, api goetcd.KeysAPI, key string) <-chan []byte {
-	respChan := make(chan []byte)
+func watchWithContext(ctx context.Context, api goetcd.KeysAPI, key string) <-chan *goetcd.Node {
+	respChan := make(chan *goetcd.Node)
 	go func() {
 		watcher := api.Watcher(key, nil)
 		for {
@@ -35,14 +117,15 @@ func watchWithContext(ctx context.Context, api goetcd.KeysAPI, key string) <-cha
 				time.Sleep(time.Second * 5)
 				continue
 			}
-			respChan <- []byte(resp.Node.Value)
+			respChan <- resp.Node
 		}
 	}()
 	return respChan
 }
 
 // NewEtcdLayerContext reads config from a etcd key, it should encode with one of the know formats and
-// optionally can be encrypted using cipher.
+// optionally can be encrypted using cipher. the layer implements the onion.WritableLayer with
+// compare and swap on the key, if it is not encrypted.
 func NewEtcdLayerContext(ctx context.Context, key string, format string, endPoints []string, c onion.Cipher) (onion.Layer, error) {
 	cl, err := goetcd.New(goetcd.Config{
 		Endpoints: endPoints,
@@ -50,18 +133,29 @@ func NewEtcdLayerContext(ctx context.Context, key string, format string, endPoin
 	if err != nil {
 		return nil, err
 	}
-	api := goetcd.NewKeysAPI(cl)
-	buf, err := getWithContext(ctx, api, key)
+	return newEtcdLayer(ctx, goetcd.NewKeysAPI(cl), key, format, c)
+}
+
+func newEtcdLayer(ctx context.Context, api goetcd.KeysAPI, key string, format string, c onion.Cipher) (onion.Layer, error) {
+	node, err := getWithContext(ctx, api, key)
 	if err != nil {
 		return nil, err
 	}
 
-	l, err := onion.NewStreamLayerContext(ctx, buf, format, c)
+	l, err := onion.NewStreamLayerContext(ctx, bytes.NewReader([]byte(node.Value)), format, c)
 	if err != nil {
 		return nil, err
 	}
 
-	sl := l.(streamReload)
+	el := &etcdLayer{
+		Layer:  l,
+		api:    api,
+		key:    key,
+		format: format,
+		cipher: c,
+		value:  node.Value,
+		index:  node.ModifiedIndex,
+	}
 
 	go func() {
 		watch := watchWithContext(ctx, api, key)
@@ -69,15 +163,15 @@ func NewEtcdLayerContext(ctx context.Context, key string, format string, endPoin
 			select {
 			case <-ctx.Done():
 				return
-			case b := <-watch:
-				if err := sl.Reload(ctx, bytes.NewReader(b), format); err != nil {
+			case n := <-watch:
+				if err := el.reload(ctx, n); err != nil {
 					log.Println("error:", err) // Better log support
 				}
 			}
 		}
 	}()
 
-	return l, nil
+	return el, nil
 }
 
 // NewEtcdLayer creates a new etcd layer
@@ -2,6 +2,7 @@ package etcdlayer
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -42,3 +43,75 @@ func TestNewEtcdLayerContext(t *testing.T) {
 	})
 
 }
+
+// fakeKeysAPI is an in memory etcd key with compare and swap, the other methods are not used
+type fakeKeysAPI struct {
+	client.KeysAPI
+
+	lock  sync.Mutex
+	value string
+	index uint64
+}
+
+func (f *fakeKeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return &client.Response{Node: &client.Node{Key: key, Value: f.value, ModifiedIndex: f.index}}, nil
+}
+
+func (f *fakeKeysAPI) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if opts != nil && opts.PrevIndex != 0 && opts.PrevIndex != f.index {
+		return nil, client.Error{Code: client.ErrorCodeTestFailed, Message: "Compare failed"}
+	}
+	f.value = value
+	f.index++
+	return &client.Response{Node: &client.Node{Key: key, Value: f.value, ModifiedIndex: f.index}}, nil
+}
+
+func (f *fakeKeysAPI) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	return fakeWatcher{}
+}
+
+// fakeWatcher never reports a change, so the conflict is not resolved by the watch
+type fakeWatcher struct{}
+
+func (fakeWatcher) Next(ctx context.Context) (*client.Response, error) {
+	select {}
+}
+
+func TestEtcdLayerCommit(t *testing.T) {
+	Convey("Write back into etcd with compare and swap", t, func() {
+		api := &fakeKeysAPI{value: `{"hi": 100}`, index: 1}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l, err := newEtcdLayer(ctx, api, "/app/writable", "json", nil)
+		So(err, ShouldBeNil)
+		wl := l.(onion.WritableLayer)
+		So(l.Load()["hi"], ShouldEqual, 100)
+
+		So(wl.Set("hi", 200), ShouldBeNil)
+		So(wl.Commit(ctx), ShouldBeNil)
+		So(api.value, ShouldContainSubstring, "200")
+		So(l.Load()["hi"], ShouldEqual, 200)
+
+		// Changed by someone else
+		_, err = api.Set(ctx, "/app/writable", `{"hi": 300}`, nil)
+		So(err, ShouldBeNil)
+		So(wl.Set("hi", 400), ShouldBeNil)
+		err = wl.Commit(ctx)
+		So(errors.Is(err, ErrConflict), ShouldBeTrue)
+		So(api.value, ShouldContainSubstring, "300")
+
+		// Retry after the reload
+		resp, err := api.Get(ctx, "/app/writable", nil)
+		So(err, ShouldBeNil)
+		So(l.(*etcdLayer).reload(ctx, resp.Node), ShouldBeNil)
+		So(wl.Commit(ctx), ShouldBeNil)
+		So(api.value, ShouldContainSubstring, "400")
+	})
+}
@@ -0,0 +1,14 @@
+package etcdlayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		return NewEtcdLayerContext(ctx, opt.GetString("key"), opt.GetStringDefault("format", "json"), opt.GetStringSlice("endpoints"), spec.Cipher)
+	}, "etcd")
+}
@@ -0,0 +1,254 @@
+// Package filekeylayer is a layer for the directories with one file per value, like the Docker
+// secrets (/run/secrets/db_password) or the Kubernetes secret volumes. the file name is the key and
+// the file content is the value.
+package filekeylayer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/fswatch"
+)
+
+// Options is the options of the file key layer
+type Options struct {
+	// Nested maps the sub directories into the nested keys (db/password is db.password), if it is
+	// false the sub directories are ignored
+	Nested bool
+	// MaxSize is the maximum size of a file, larger files are an error. zero means no limit
+	MaxSize int64
+	// Secret reports all the keys as secrets, see onion.SecretLayer
+	Secret bool
+	// Watch reloads the layer when a file is changed, until the context is done
+	Watch bool
+}
+
+type fileKeyLayer struct {
+	lock    sync.RWMutex
+	dir     string
+	opt     Options
+	data    map[string]interface{}
+	secrets [][]string
+	sender  *onion.Sender
+}
+
+func (fl *fileKeyLayer) Load() map[string]interface{} {
+	fl.lock.RLock()
+	defer fl.lock.RUnlock()
+
+	return fl.data
+}
+
+func (fl *fileKeyLayer) Watch() <-chan map[string]interface{} {
+	return fl.sender.Watch()
+}
+
+func (fl *fileKeyLayer) SecretKeys() [][]string {
+	fl.lock.RLock()
+	defer fl.lock.RUnlock()
+
+	return fl.secrets
+}
+
+// ReloadLayer reads the directory again, the new data is sent to the watch channel only if it is
+// changed
+func (fl *fileKeyLayer) ReloadLayer(_ context.Context) error {
+	data, secrets, err := fl.read()
+	if err != nil {
+		return err
+	}
+
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	if reflect.DeepEqual(data, fl.data) && reflect.DeepEqual(secrets, fl.secrets) {
+		return nil
+	}
+	fl.data, fl.secrets = data, secrets
+	fl.sender.Send(data)
+	return nil
+}
+
+// value returns the file content, the text values are string without the trailing new lines and
+// the binary values are []byte
+func value(b []byte) interface{} {
+	if !utf8.Valid(b) || bytes.IndexByte(b, 0) >= 0 {
+		return b
+	}
+	return strings.TrimRight(string(b), "\r\n")
+}
+
+// readDir reads the files in the directory, the parents are the directories in the path, they are
+// used to skip the symlinks to a parent directory
+func (fl *fileKeyLayer) readDir(dir string, parents []os.FileInfo, data map[string]interface{}, secrets *[][]string, prefix ...string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		// Hidden files, also the ..data and timestamped directories of the Kubernetes volumes
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		// Follow the symlinks
+		st, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		key := append(append([]string{}, prefix...), e.Name())
+		if st.IsDir() {
+			if !fl.opt.Nested || isParent(st, parents) {
+				continue
+			}
+			sub := make(map[string]interface{})
+			if err := fl.readDir(path, append(parents, st), sub, secrets, key...); err != nil {
+				return err
+			}
+			data[e.Name()] = sub
+			continue
+		}
+
+		if fl.opt.MaxSize > 0 && st.Size() > fl.opt.MaxSize {
+			return fmt.Errorf("file %q is larger than %d bytes", path, fl.opt.MaxSize)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data[e.Name()] = value(b)
+		if fl.opt.Secret {
+			*secrets = append(*secrets, key)
+		}
+	}
+	return nil
+}
+
+func isParent(st os.FileInfo, parents []os.FileInfo) bool {
+	for i := range parents {
+		if os.SameFile(st, parents[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fl *fileKeyLayer) read() (map[string]interface{}, [][]string, error) {
+	st, err := os.Stat(fl.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make(map[string]interface{})
+	var secrets [][]string
+	if err := fl.readDir(fl.dir, []os.FileInfo{st}, data, &secrets); err != nil {
+		return nil, nil, err
+	}
+	return data, secrets, nil
+}
+
+// dirs returns the directory and all the sub directories (if nested) to watch
+func (fl *fileKeyLayer) dirs() ([]string, error) {
+	if !fl.opt.Nested {
+		return []string{fl.dir}, nil
+	}
+	var res []string
+	err := filepath.Walk(fl.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != fl.dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		res = append(res, path)
+		return nil
+	})
+	return res, err
+}
+
+// watch reloads the layer on the changes, in the nested mode the watch is started again when the
+// sub directories are changed
+func (fl *fileKeyLayer) watch(ctx context.Context, dirs []string, changes <-chan struct{}, cancel context.CancelFunc) {
+	defer func() { cancel() }()
+
+	for {
+		// The changes is replaced in the loop, so it is not a range
+		if _, ok := <-changes; !ok {
+			return
+		}
+		if fl.opt.Nested {
+			if current, err := fl.dirs(); err != nil {
+				log.Println("error:", err) // Better log support
+			} else if !reflect.DeepEqual(current, dirs) {
+				// The new directories are watched before the reload, so their files are not missed
+				watchCtx, watchCancel := context.WithCancel(ctx)
+				next, err := fswatch.Watch(watchCtx, current...)
+				if err != nil {
+					watchCancel()
+					log.Println("error:", err) // Better log support
+				} else {
+					cancel()
+					dirs, changes, cancel = current, next, watchCancel
+				}
+			}
+		}
+
+		if err := fl.ReloadLayer(ctx); err != nil {
+			log.Println("error:", err) // Better log support
+		}
+	}
+}
+
+// NewFileKeyLayerContext creates a layer from a directory, each file is a key and its content is
+// the value. text values are strings without the trailing new lines, and binary values are []byte.
+// the hidden files are ignored. the layer implements onion.Reloader, and with the Watch option it
+// watches the directory (with fsnotify, or polling if it is not available) until the context is done
+func NewFileKeyLayerContext(ctx context.Context, dir string, opt Options) (onion.Layer, error) {
+	fl := &fileKeyLayer{
+		dir:    filepath.Clean(dir),
+		opt:    opt,
+		sender: onion.NewSender(ctx),
+	}
+
+	var err error
+	if fl.data, fl.secrets, err = fl.read(); err != nil {
+		return nil, err
+	}
+
+	if !opt.Watch {
+		return fl, nil
+	}
+
+	dirs, err := fl.dirs()
+	if err != nil {
+		return nil, err
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	changes, err := fswatch.Watch(watchCtx, dirs...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go fl.watch(ctx, dirs, changes, cancel)
+
+	return fl, nil
+}
+
+// NewFileKeyLayer creates a new file key layer, see NewFileKeyLayerContext
+func NewFileKeyLayer(dir string, opt Options) (onion.Layer, error) {
+	return NewFileKeyLayerContext(context.Background(), dir, opt)
+}
@@ -0,0 +1,105 @@
+package filekeylayer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewFileKeyLayerContext(t *testing.T) {
+	Convey("Test file per key layer", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-filekey-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		So(ioutil.WriteFile(filepath.Join(dir, "db_password"), []byte("s3cret\n"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "cert"), []byte{0, 1, 2, 0xff}, 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("no"), 0600), ShouldBeNil)
+		So(os.Mkdir(filepath.Join(dir, "api"), 0700), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "api", "token"), []byte("t0k3n\r\n"), 0600), ShouldBeNil)
+
+		Convey("flat", func() {
+			l, err := NewFileKeyLayerContext(ctx, dir, Options{})
+			So(err, ShouldBeNil)
+			o := onion.New(l)
+			So(o.GetString("db_password"), ShouldEqual, "s3cret")
+			v, _ := o.Get("cert")
+			So(v, ShouldResemble, []byte{0, 1, 2, 0xff})
+			_, ok := o.Get(".hidden")
+			So(ok, ShouldBeFalse)
+			_, ok = o.Get("api")
+			So(ok, ShouldBeFalse)
+			So(o.SecretKeys(), ShouldBeEmpty)
+		})
+
+		Convey("nested and secret", func() {
+			l, err := NewFileKeyLayerContext(ctx, dir, Options{Nested: true, Secret: true})
+			So(err, ShouldBeNil)
+			o := onion.New(l)
+			So(o.GetString("api.token"), ShouldEqual, "t0k3n")
+			So(o.SecretKeys(), ShouldContain, "api.token")
+			So(o.SecretKeys(), ShouldContain, "db_password")
+		})
+
+		Convey("symlink to a parent directory", func() {
+			So(os.Symlink(dir, filepath.Join(dir, "api", "loop")), ShouldBeNil)
+			l, err := NewFileKeyLayerContext(ctx, dir, Options{Nested: true})
+			So(err, ShouldBeNil)
+			o := onion.New(l)
+			So(o.GetString("api.token"), ShouldEqual, "t0k3n")
+			_, ok := o.Get("api.loop")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("size limit", func() {
+			_, err := NewFileKeyLayerContext(ctx, dir, Options{MaxSize: 4})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("watch", func() {
+			l, err := NewFileKeyLayerContext(ctx, dir, Options{Nested: true, Watch: true})
+			So(err, ShouldBeNil)
+			o := onion.NewContext(ctx, l)
+
+			watch := o.ReloadWatch()
+			So(ioutil.WriteFile(filepath.Join(dir, ".tmp"), []byte("new"), 0600), ShouldBeNil)
+			So(os.Rename(filepath.Join(dir, ".tmp"), filepath.Join(dir, "db_password")), ShouldBeNil)
+			<-watch
+			So(o.GetString("db_password"), ShouldEqual, "new")
+
+			watch = o.ReloadWatch()
+			So(os.Mkdir(filepath.Join(dir, "cache"), 0700), ShouldBeNil)
+			<-watch
+			watch = o.ReloadWatch()
+			So(ioutil.WriteFile(filepath.Join(dir, "cache", "host"), []byte("redis"), 0600), ShouldBeNil)
+			<-watch
+			So(o.GetString("cache.host"), ShouldEqual, "redis")
+		})
+	})
+}
+
+func TestFileKeyLayerSpec(t *testing.T) {
+	Convey("File key layer from the spec", t, func() {
+		dir, err := ioutil.TempDir("", "onion-filekey-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		// The nested and secret options
+		So(os.Mkdir(filepath.Join(dir, "db"), 0700), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "db", "password"), []byte("secret\n"), 0600), ShouldBeNil)
+		spec := `{"layers": [{"type": "filekey", "path": "` + dir + `", "nested": true, "secret": true}]}`
+		o, err := onion.FromSpec(strings.NewReader(spec), "json", nil)
+		So(err, ShouldBeNil)
+		So(o.GetString("db.password"), ShouldEqual, "secret")
+		So(o.SecretKeys(), ShouldResemble, []string{"db.password"})
+	})
+}
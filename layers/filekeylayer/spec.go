@@ -0,0 +1,19 @@
+package filekeylayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		return NewFileKeyLayerContext(ctx, opt.GetString("path"), Options{
+			Nested:  opt.GetBool("nested"),
+			MaxSize: opt.GetInt64("max_size"),
+			Secret:  opt.GetBool("secret"),
+			Watch:   opt.GetBool("watch"),
+		})
+	}, "filekey")
+}
@@ -3,75 +3,164 @@
 package filewatchlayer
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/fswatch"
 )
 
 type streamReload interface {
 	Reload(context.Context, io.Reader, string) error
 }
 
-func reload(ctx context.Context, path string, fl streamReload, ext string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
+// watchDirs returns the parent directory of the file, and if the file is a symlink the directory
+// of the target file too
+func watchDirs(path string) []string {
+	dirs := []string{filepath.Dir(path)}
+	if target, err := filepath.EvalSymlinks(path); err == nil && filepath.Dir(target) != dirs[0] {
+		dirs = append(dirs, filepath.Dir(target))
 	}
-	defer func() { _ = f.Close() }()
-
-	return fl.Reload(ctx, f, ext)
+	return dirs
 }
 
 // NewFileWatchLayerContext create a file layer with automatic fswatch.
 // it reloads if the file content has changed, also the watch finish with the context
-// a non-nil cipher is used to load encrypted file, nil means plain file
+// a non-nil cipher is used to load encrypted file, nil means plain file.
+// the parent directory is watched, so the editors that save by rename, the removed and created
+// files and the symlink swaps are supported. the layer is reloaded only if the content is changed.
+// if fsnotify is not available, it falls back to polling. the layer implements onion.Reloader too
 func NewFileWatchLayerContext(ctx context.Context, path string, c onion.Cipher) (onion.Layer, error) {
-	l, err := onion.NewFileLayerContext(ctx, path, c)
+	return newWatchLayer(ctx, path, c, fswatch.Watch)
+}
+
+// NewFilePollLayerContext is the same as NewFileWatchLayerContext, but it checks the file at every
+// interval instead of fsnotify. it is useful on the file systems that fsnotify does not work, like
+// NFS or some FUSE mounts
+func NewFilePollLayerContext(ctx context.Context, path string, c onion.Cipher, interval time.Duration) (onion.Layer, error) {
+	return newWatchLayer(ctx, path, c, fswatch.Poll(interval))
+}
+
+// NewFilePollLayer create a file layer with polling, see NewFilePollLayerContext
+func NewFilePollLayer(path string, c onion.Cipher, interval time.Duration) (onion.Layer, error) {
+	return NewFilePollLayerContext(context.Background(), path, c, interval)
+}
+
+type watchLayer struct {
+	onion.Layer
+	lock sync.Mutex
+	path string
+	ext  string
+	hash [sha256.Size]byte
+}
+
+// ReloadLayer reads the file again, the layer is reloaded only if the content is changed
+func (wl *watchLayer) ReloadLayer(ctx context.Context) error {
+	b, err := ioutil.ReadFile(wl.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	ext := strings.TrimPrefix(filepath.Ext(path), ".")
-	sl := l.(streamReload)
-	watch, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	sum := sha256.Sum256(b)
+	if sum == wl.hash {
+		return nil
+	}
+	if err := wl.Layer.(streamReload).Reload(ctx, bytes.NewReader(b), wl.ext); err != nil {
+		return err
 	}
+	wl.hash = sum
+	return nil
+}
 
-	if err := watch.Add(path); err != nil {
-		return nil, err
+func sameDirs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
+	return true
+}
 
-	go func() {
-		defer func() { _ = watch.Close() }()
-		select {
-		case <-ctx.Done():
-			return
-		case event, ok := <-watch.Events:
-			if !ok {
-				return
+// watch reloads the layer on the changes, the watched directories are checked after each change
+// since a symlink swap may point the file into another directory. the cancel stops the current watch
+func (wl *watchLayer) watch(ctx context.Context, watch fswatch.WatchFunc, dirs []string, changes <-chan struct{}, cancel context.CancelFunc) {
+	for {
+		moved := false
+		for range changes {
+			// The file may be removed, or in the middle of a rename. wait for the next change
+			if err := wl.ReloadLayer(ctx); err != nil && !os.IsNotExist(err) {
+				log.Println("error:", err) // Better log support
 			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				time.Sleep(time.Second) // sometime it triggers before the complete write TODO: find a solution (not hack)
-				if err := reload(ctx, path, sl, ext); err != nil {
-					log.Println("error:", err) // Better log support
-				}
-			}
-		case err, ok := <-watch.Errors:
-			if !ok {
-				return
+			if current := watchDirs(wl.path); !sameDirs(current, dirs) {
+				dirs, moved = current, true
+				break
 			}
+		}
+		cancel()
+		if !moved || ctx.Err() != nil {
+			return
+		}
+
+		var (
+			wctx context.Context
+			err  error
+		)
+		wctx, cancel = context.WithCancel(ctx)
+		if changes, err = watch(wctx, dirs...); err != nil {
+			cancel()
+			log.Println("error:", err)
+			return
+		}
+		// The changes before the new watch are not reported
+		if err := wl.ReloadLayer(ctx); err != nil && !os.IsNotExist(err) {
 			log.Println("error:", err)
 		}
-	}()
+	}
+}
+
+func newWatchLayer(ctx context.Context, path string, c onion.Cipher, watch fswatch.WatchFunc) (onion.Layer, error) {
+	path = filepath.Clean(path)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	l, err := onion.NewStreamLayerContext(ctx, bytes.NewReader(b), ext, c)
+	if err != nil {
+		return nil, err
+	}
+	wl := &watchLayer{
+		Layer: l,
+		path:  path,
+		ext:   ext,
+		hash:  sha256.Sum256(b),
+	}
+
+	dirs := watchDirs(path)
+	wctx, cancel := context.WithCancel(ctx)
+	changes, err := watch(wctx, dirs...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go wl.watch(ctx, watch, dirs, changes, cancel)
 
-	return l, nil
+	return wl, nil
 }
 
 // NewFileWatchLayer create a file layer with automatic fswatch.
@@ -1,9 +1,11 @@
 package filewatchlayer
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -48,3 +50,107 @@ func TestNewFileWatchLayerContext(t *testing.T) {
 		So(o.GetInt("hi"), ShouldEqual, 200)
 	})
 }
+
+func waitFor(o *onion.Onion, key string, value int) bool {
+	deadline := time.After(5 * time.Second)
+	for {
+		watch := o.ReloadWatch()
+		if o.GetInt(key) == value {
+			return true
+		}
+		select {
+		case <-watch:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func TestFileWatchPatterns(t *testing.T) {
+	Convey("Test the common save patterns", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-filewatch-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		fl := filepath.Join(dir, "config.json")
+		So(writeJson(fl, map[string]interface{}{"hi": 1}), ShouldBeNil)
+		l, err := NewFileWatchLayerContext(ctx, fl, nil)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetInt("hi"), ShouldEqual, 1)
+
+		// Write in place, more than once
+		So(writeJson(fl, map[string]interface{}{"hi": 2}), ShouldBeNil)
+		So(waitFor(o, "hi", 2), ShouldBeTrue)
+		So(writeJson(fl, map[string]interface{}{"hi": 3}), ShouldBeNil)
+		So(waitFor(o, "hi", 3), ShouldBeTrue)
+
+		// Save by rename, like vim and most editors
+		tmp := filepath.Join(dir, ".config.json.swp")
+		So(writeJson(tmp, map[string]interface{}{"hi": 4}), ShouldBeNil)
+		So(os.Rename(tmp, fl), ShouldBeNil)
+		So(waitFor(o, "hi", 4), ShouldBeTrue)
+
+		// Remove and create
+		So(os.Remove(fl), ShouldBeNil)
+		So(writeJson(fl, map[string]interface{}{"hi": 5}), ShouldBeNil)
+		So(waitFor(o, "hi", 5), ShouldBeTrue)
+	})
+
+	Convey("Test symlink swap", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-filewatch-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		So(os.Mkdir(filepath.Join(dir, "v1"), 0755), ShouldBeNil)
+		So(os.Mkdir(filepath.Join(dir, "v2"), 0755), ShouldBeNil)
+		So(writeJson(filepath.Join(dir, "v1", "config.json"), map[string]interface{}{"hi": 1}), ShouldBeNil)
+		So(writeJson(filepath.Join(dir, "v2", "config.json"), map[string]interface{}{"hi": 2}), ShouldBeNil)
+		So(os.Symlink(filepath.Join("v1", "config.json"), filepath.Join(dir, "config.json")), ShouldBeNil)
+
+		l, err := NewFileWatchLayerContext(ctx, filepath.Join(dir, "config.json"), nil)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetInt("hi"), ShouldEqual, 1)
+
+		So(os.Symlink(filepath.Join("v2", "config.json"), filepath.Join(dir, "tmp")), ShouldBeNil)
+		So(os.Rename(filepath.Join(dir, "tmp"), filepath.Join(dir, "config.json")), ShouldBeNil)
+		So(waitFor(o, "hi", 2), ShouldBeTrue)
+
+		// The new target directory is watched after the swap
+		So(writeJson(filepath.Join(dir, "v2", "config.json"), map[string]interface{}{"hi": 3}), ShouldBeNil)
+		So(waitFor(o, "hi", 3), ShouldBeTrue)
+	})
+}
+
+func TestNewFilePollLayerContext(t *testing.T) {
+	Convey("Test polling file layer", t, func() {
+		dir, err := ioutil.TempDir(os.TempDir(), "onion-filepoll-*")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		fl := filepath.Join(dir, "config.json")
+		So(writeJson(fl, map[string]interface{}{"hi": 1}), ShouldBeNil)
+		l, err := NewFilePollLayerContext(ctx, fl, nil, 20*time.Millisecond)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetInt("hi"), ShouldEqual, 1)
+
+		So(writeJson(fl, map[string]interface{}{"hi": 22}), ShouldBeNil)
+		So(waitFor(o, "hi", 22), ShouldBeTrue)
+
+		tmp := filepath.Join(dir, "config.tmp")
+		So(writeJson(tmp, map[string]interface{}{"hi": 3}), ShouldBeNil)
+		So(os.Rename(tmp, fl), ShouldBeNil)
+		So(waitFor(o, "hi", 3), ShouldBeTrue)
+	})
+}
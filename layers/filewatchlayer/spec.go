@@ -0,0 +1,17 @@
+package filewatchlayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		path := spec.Options.GetString("path")
+		if interval := spec.Options.GetDuration("poll_interval"); interval > 0 {
+			return NewFilePollLayerContext(ctx, path, spec.Cipher, interval)
+		}
+		return NewFileWatchLayerContext(ctx, path, spec.Cipher)
+	}, "filewatch")
+}
@@ -0,0 +1,126 @@
+// Package flaglayer is a layer based on the command line flags, both the standard flag package and
+// github.com/ogier/pflag are supported. the flag names are mapped to nested keys, so the `db-host`
+// flag overrides the `db.host` key.
+package flaglayer
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/maputil"
+	"github.com/ogier/pflag"
+)
+
+func splitName(name, separator string) []string {
+	if separator == "" {
+		return []string{name}
+	}
+	return strings.Split(name, separator)
+}
+
+// pflagDuration is the type of the pflag duration values, the pflag version in use has no
+// Value.Type() so the type of a real duration flag is used
+var pflagDuration = func() reflect.Type {
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.Duration("d", 0, "")
+	return reflect.TypeOf(fs.Lookup("d").Value)
+}()
+
+// isDuration returns true for the pflag duration values, the values with the Type method (newer
+// pflag versions) are checked by the type name of the flag
+func isDuration(v interface{}) bool {
+	if t, ok := v.(interface{ Type() string }); ok {
+		return t.Type() == "duration"
+	}
+	return reflect.TypeOf(v) == pflagDuration
+}
+
+// flagValue returns the typed value of the flag, standard flags implement flag.Getter, pflag values
+// are the named types of the basic types (like `type intValue int`) so the reflection is used for
+// them.
+func flagValue(v interface{ String() string }) interface{} {
+	if g, ok := v.(flag.Getter); ok {
+		return g.Get()
+	}
+	if isDuration(v) {
+		return time.Duration(reflect.Indirect(reflect.ValueOf(v)).Int())
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Int:
+		return int(rv.Int())
+	case reflect.Int8:
+		return int8(rv.Int())
+	case reflect.Int16:
+		return int16(rv.Int())
+	case reflect.Int32:
+		return int32(rv.Int())
+	case reflect.Int64:
+		return rv.Int()
+	case reflect.Uint:
+		return uint(rv.Uint())
+	case reflect.Uint8:
+		return uint8(rv.Uint())
+	case reflect.Uint16:
+		return uint16(rv.Uint())
+	case reflect.Uint32:
+		return uint32(rv.Uint())
+	case reflect.Uint64:
+		return rv.Uint()
+	case reflect.Float32:
+		return float32(rv.Float())
+	case reflect.Float64:
+		return rv.Float()
+	}
+
+	return v.String()
+}
+
+// NewFlagLayer creates a layer from a parsed flag set, nil means the flag.CommandLine. the flag
+// names are split by the separator to create the nested keys. only the flags set in the command
+// line are in the layer, unless the all is true, then the default value of the other flags are
+// also in the layer.
+func NewFlagLayer(fs *flag.FlagSet, separator string, all bool) onion.Layer {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+
+	var data map[string]interface{}
+	visit := func(f *flag.Flag) {
+		data = maputil.Build(data, flagValue(f.Value), splitName(f.Name, separator)...)
+	}
+	if all {
+		fs.VisitAll(visit)
+	} else {
+		fs.Visit(visit)
+	}
+
+	return onion.NewMapLayer(data)
+}
+
+// NewPFlagLayer is the NewFlagLayer for the pflag package, nil means the pflag.CommandLine
+func NewPFlagLayer(fs *pflag.FlagSet, separator string, all bool) onion.Layer {
+	if fs == nil {
+		fs = pflag.CommandLine
+	}
+
+	var data map[string]interface{}
+	visit := func(f *pflag.Flag) {
+		data = maputil.Build(data, flagValue(f.Value), splitName(f.Name, separator)...)
+	}
+	if all {
+		fs.VisitAll(visit)
+	} else {
+		fs.Visit(visit)
+	}
+
+	return onion.NewMapLayer(data)
+}
@@ -0,0 +1,90 @@
+package flaglayer
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/ogier/pflag"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type typedValue int64
+
+func (v *typedValue) String() string { return time.Duration(*v).String() }
+func (v *typedValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	*v = typedValue(d)
+	return err
+}
+func (v *typedValue) Type() string { return "duration" }
+
+type durationValue int64
+
+func (v *durationValue) String() string     { return "" }
+func (v *durationValue) Set(s string) error { return nil }
+
+func TestNewFlagLayer(t *testing.T) {
+	Convey("Standard flag layer", t, func() {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("db-host", "localhost", "")
+		fs.Int("db-port", 5432, "")
+		fs.Bool("debug", false, "")
+		fs.Duration("timeout", time.Second, "")
+		So(fs.Parse([]string{"-db-host", "db.local", "-timeout", "1m", "-debug"}), ShouldBeNil)
+
+		o := onion.New(onion.NewMapLayer(map[string]interface{}{
+			"db": map[string]interface{}{"port": 3306},
+		}), NewFlagLayer(fs, "-", false))
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetInt("db.port"), ShouldEqual, 3306)
+		So(o.GetBool("debug"), ShouldBeTrue)
+		v, ok := o.Get("timeout")
+		So(ok, ShouldBeTrue)
+		So(v, ShouldEqual, time.Minute)
+
+		all := onion.New(NewFlagLayer(fs, "-", true))
+		So(all.GetInt("db.port"), ShouldEqual, 5432)
+		v, _ = all.Get("db.port")
+		So(v, ShouldHaveSameTypeAs, 0)
+	})
+
+	Convey("pflag layer", t, func() {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.StringP("db-host", "H", "localhost", "")
+		fs.Int("db-port", 5432, "")
+		fs.Float64("ratio", 0.5, "")
+		fs.Duration("timeout", time.Second, "")
+		fs.Uint8("level", 1, "")
+		So(fs.Parse([]string{"-H", "db.local", "--timeout=1m", "--db-port=1"}), ShouldBeNil)
+
+		o := onion.New(NewPFlagLayer(fs, "-", false))
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		v, _ := o.Get("db.port")
+		So(v, ShouldEqual, 1)
+		v, _ = o.Get("timeout")
+		So(v, ShouldEqual, time.Minute)
+		_, ok := o.Get("ratio")
+		So(ok, ShouldBeFalse)
+
+		all := onion.New(NewPFlagLayer(fs, "", true))
+		So(all.GetFloat64("ratio"), ShouldEqual, 0.5)
+		So(all.GetString("db-host"), ShouldEqual, "db.local")
+		v, _ = all.Get("level")
+		So(v, ShouldEqual, uint8(1))
+	})
+
+	Convey("pflag durations are detected by the value type", t, func() {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		tv, dv := typedValue(time.Second), durationValue(10)
+		fs.Var(&tv, "typed", "")
+		fs.Var(&dv, "other", "")
+
+		o := onion.New(NewPFlagLayer(fs, "", true))
+		v, _ := o.Get("typed")
+		So(v, ShouldEqual, time.Second)
+		v, _ = o.Get("other")
+		So(v, ShouldEqual, int64(10))
+	})
+}
@@ -0,0 +1,303 @@
+package flaglayer
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/maputil"
+	"github.com/ogier/pflag"
+)
+
+// definer is the common part of the flag.FlagSet and pflag.FlagSet
+type definer interface {
+	String(name string, value string, usage string) *string
+	Bool(name string, value bool, usage string) *bool
+	Int(name string, value int, usage string) *int
+	Int64(name string, value int64, usage string) *int64
+	Uint64(name string, value uint64, usage string) *uint64
+	Float64(name string, value float64, usage string) *float64
+	Duration(name string, value time.Duration, usage string) *time.Duration
+}
+
+type registeredLayer struct {
+	load func() map[string]interface{}
+}
+
+func (rl *registeredLayer) Load() map[string]interface{} {
+	return rl.load()
+}
+
+func (rl *registeredLayer) Watch() <-chan map[string]interface{} {
+	return nil
+}
+
+func defineFlag(fs definer, name string, value interface{}, usage string) {
+	switch v := value.(type) {
+	case string:
+		fs.String(name, v, usage)
+	case bool:
+		fs.Bool(name, v, usage)
+	case int:
+		fs.Int(name, v, usage)
+	case int64:
+		fs.Int64(name, v, usage)
+	case uint64:
+		fs.Uint64(name, v, usage)
+	case time.Duration:
+		fs.Duration(name, v, usage)
+	case float64:
+		fs.Float64(name, v, usage)
+	case float32:
+		fs.Float64(name, float64(v), usage)
+	case []string:
+		fs.String(name, strings.Join(v, ","), usage)
+	case []interface{}:
+		s := make([]string, len(v))
+		for i := range v {
+			s[i] = fmt.Sprint(v[i])
+		}
+		fs.String(name, strings.Join(s, ","), usage)
+	default:
+		fs.String(name, fmt.Sprint(v), usage)
+	}
+}
+
+func collectKeys(keys map[string]bool, m interface{}, delimiter string, prefix ...string) {
+	add := func(k string, v interface{}) {
+		path := append(append([]string{}, prefix...), k)
+		switch v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			collectKeys(keys, v, delimiter, path...)
+		default:
+			keys[strings.Join(path, delimiter)] = true
+		}
+	}
+
+	switch t := m.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			add(k, t[k])
+		}
+	case map[interface{}]interface{}:
+		for k := range t {
+			add(fmt.Sprint(k), t[k])
+		}
+	}
+}
+
+// onionDefaults returns the current value of all the leaf keys in the onion
+func onionDefaults(o *onion.Onion) map[string]interface{} {
+	delimiter := o.GetDelimiter()
+	keys := make(map[string]bool)
+	for _, data := range o.LayersData() {
+		collectKeys(keys, data, delimiter)
+	}
+
+	res := make(map[string]interface{}, len(keys))
+	for k := range keys {
+		v, ok := o.Get(k)
+		if !ok {
+			continue
+		}
+		switch t := v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			// The upper layer replaced the value with a map, the children are collected separately
+			continue
+		case float64:
+			// json numbers are always float64, if there is no fraction an int flag is more usable
+			if t == math.Trunc(t) && math.Abs(t) < math.MaxInt64 {
+				v = int64(t)
+			}
+		}
+		res[k] = v
+	}
+	return res
+}
+
+func structName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// structValue converts the field to one of the types supported by defineFlag
+func structValue(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return int(v.Int()), true
+	case reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			return time.Duration(v.Int()), true
+		}
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil, false
+		}
+		s := make([]string, v.Len())
+		for i := range s {
+			s[i] = v.Index(i).String()
+		}
+		return s, true
+	}
+	return nil, false
+}
+
+// structDefaults walks over the struct with the same naming as the structlayer (mapstructure tag
+// or the field name), the usage is from the `usage` tag. the nil pointers are the zero value of
+// their type
+func structDefaults(defaults map[string]interface{}, usage map[string]string, v reflect.Value, delimiter string, prefix ...string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("mapstructure") == "-" {
+			continue
+		}
+		path := append(append([]string{}, prefix...), structName(f))
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv = reflect.Zero(f.Type.Elem())
+			} else {
+				fv = fv.Elem()
+			}
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Time{}) {
+			if err := structDefaults(defaults, usage, fv, delimiter, path...); err != nil {
+				return err
+			}
+			continue
+		}
+		key := strings.Join(path, delimiter)
+		val, ok := structValue(fv)
+		if !ok {
+			return fmt.Errorf("the type %s of the field %q is not supported", f.Type, key)
+		}
+		defaults[key] = val
+		if u := f.Tag.Get("usage"); u != "" {
+			usage[key] = u
+		}
+	}
+	return nil
+}
+
+// register defines the flags in the order of the keys and returns the flag name to key map
+func register(fs definer, exists func(string) bool, defaults map[string]interface{}, usage map[string]string, delimiter, separator string) map[string]string {
+	keys := make([]string, 0, len(defaults))
+	for k := range defaults {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	names := make(map[string]string, len(keys))
+	for _, k := range keys {
+		name := strings.ReplaceAll(k, delimiter, separator)
+		if exists(name) {
+			continue
+		}
+		defineFlag(fs, name, defaults[k], usage[k])
+		names[name] = k
+	}
+	return names
+}
+
+func overridden(names map[string]string, delimiter string, visit func(func(name string, value interface{}))) map[string]interface{} {
+	var data map[string]interface{}
+	visit(func(name string, value interface{}) {
+		if k, ok := names[name]; ok {
+			data = maputil.Build(data, value, strings.Split(k, delimiter)...)
+		}
+	})
+	return data
+}
+
+func stdLayer(fs *flag.FlagSet, defaults map[string]interface{}, usage map[string]string, delimiter, separator string) onion.Layer {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	names := register(fs, func(n string) bool { return fs.Lookup(n) != nil }, defaults, usage, delimiter, separator)
+	return &registeredLayer{
+		load: func() map[string]interface{} {
+			return overridden(names, delimiter, func(fn func(string, interface{})) {
+				fs.Visit(func(f *flag.Flag) { fn(f.Name, flagValue(f.Value)) })
+			})
+		},
+	}
+}
+
+func pflagLayer(fs *pflag.FlagSet, defaults map[string]interface{}, usage map[string]string, delimiter, separator string) onion.Layer {
+	if fs == nil {
+		fs = pflag.CommandLine
+	}
+	names := register(fs, func(n string) bool { return fs.Lookup(n) != nil }, defaults, usage, delimiter, separator)
+	return &registeredLayer{
+		load: func() map[string]interface{} {
+			return overridden(names, delimiter, func(fn func(string, interface{})) {
+				fs.Visit(func(f *pflag.Flag) { fn(f.Name, flagValue(f.Value)) })
+			})
+		},
+	}
+}
+
+// RegisterFlags defines a flag on the flag set for every key in the onion, the `db.host` key is
+// the `db-host` flag if the separator is "-". the flag type and its default value is based on the
+// current value in the onion and the usage text is from the usage map (the key is the config key).
+// the result layer contains only the values overridden by the user, it should be added to the
+// onion after the flag set is parsed.
+func RegisterFlags(fs *flag.FlagSet, o *onion.Onion, separator string, usage map[string]string) onion.Layer {
+	return stdLayer(fs, onionDefaults(o), usage, o.GetDelimiter(), separator)
+}
+
+// RegisterPFlags is the RegisterFlags for the pflag package
+func RegisterPFlags(fs *pflag.FlagSet, o *onion.Onion, separator string, usage map[string]string) onion.Layer {
+	return pflagLayer(fs, onionDefaults(o), usage, o.GetDelimiter(), separator)
+}
+
+// RegisterStructFlags defines a flag for every field in the struct, the key names are the same as
+// the structlayer and the usage text is from the `usage` tag. see RegisterFlags
+func RegisterStructFlags(fs *flag.FlagSet, s interface{}, separator string) (onion.Layer, error) {
+	defaults, usage, err := fromStruct(s)
+	if err != nil {
+		return nil, err
+	}
+	return stdLayer(fs, defaults, usage, onion.GetDelimiter(), separator), nil
+}
+
+// RegisterStructPFlags is the RegisterStructFlags for the pflag package
+func RegisterStructPFlags(fs *pflag.FlagSet, s interface{}, separator string) (onion.Layer, error) {
+	defaults, usage, err := fromStruct(s)
+	if err != nil {
+		return nil, err
+	}
+	return pflagLayer(fs, defaults, usage, onion.GetDelimiter(), separator), nil
+}
+
+func fromStruct(s interface{}) (map[string]interface{}, map[string]string, error) {
+	v := reflect.Indirect(reflect.ValueOf(s))
+	if v.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("%T is not a struct", s)
+	}
+
+	defaults := make(map[string]interface{})
+	usage := make(map[string]string)
+	if err := structDefaults(defaults, usage, v, onion.GetDelimiter()); err != nil {
+		return nil, nil, err
+	}
+	return defaults, usage, nil
+}
@@ -0,0 +1,113 @@
+package flaglayer
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/ogier/pflag"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type dbConfig struct {
+	Host string `usage:"database host"`
+	Port int    `mapstructure:"port"`
+}
+
+type appConfig struct {
+	DB      dbConfig `mapstructure:"db"`
+	Cache   *dbConfig
+	Debug   bool
+	Timeout time.Duration
+	Workers uint
+	Ratio   float32
+	Tags    []string
+	hidden  string
+}
+
+func TestRegisterFlags(t *testing.T) {
+	Convey("Register flags from the onion keys", t, func() {
+		base := onion.NewMapLayer(map[string]interface{}{
+			"db": map[string]interface{}{
+				"host": "localhost",
+				"port": float64(5432),
+			},
+			"hosts": []interface{}{"a", "b"},
+			"ratio": 0.5,
+		})
+		over := onion.NewMapLayer(map[string]interface{}{
+			"db": map[interface{}]interface{}{"host": "db.local"},
+		})
+		o := onion.New(base, over)
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		l := RegisterFlags(fs, o, "-", map[string]string{"db.host": "the db host"})
+
+		f := fs.Lookup("db-host")
+		So(f, ShouldNotBeNil)
+		So(f.DefValue, ShouldEqual, "db.local")
+		So(f.Usage, ShouldEqual, "the db host")
+		So(fs.Lookup("db-port").Value.(flag.Getter).Get(), ShouldEqual, int64(5432))
+		So(fs.Lookup("hosts").DefValue, ShouldEqual, "a,b")
+		So(fs.Lookup("ratio").DefValue, ShouldEqual, "0.5")
+
+		So(fs.Parse([]string{"-db-port", "6000", "-hosts", "x,y"}), ShouldBeNil)
+		So(l.Load(), ShouldResemble, map[string]interface{}{
+			"db":    map[string]interface{}{"port": int64(6000)},
+			"hosts": "x,y",
+		})
+
+		o.AddLayers(l)
+		So(o.GetInt("db.port"), ShouldEqual, 6000)
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetStringSlice("hosts"), ShouldResemble, []string{"x", "y"})
+	})
+
+	Convey("Register flags from a struct", t, func() {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		l, err := RegisterStructPFlags(fs, &appConfig{DB: dbConfig{Host: "localhost", Port: 1}, Timeout: time.Second}, "-")
+		So(err, ShouldBeNil)
+
+		So(fs.Lookup("db-Host").Usage, ShouldEqual, "database host")
+		So(fs.Lookup("db-port").DefValue, ShouldEqual, "1")
+		So(fs.Lookup("hidden"), ShouldBeNil)
+
+		So(fs.Parse([]string{"--Debug", "--Timeout=1m"}), ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetBool("Debug"), ShouldBeTrue)
+		So(o.GetDuration("Timeout"), ShouldEqual, time.Minute)
+		_, ok := o.Get("db.port")
+		So(ok, ShouldBeFalse)
+
+		So(fs.Lookup("Cache-Host").DefValue, ShouldEqual, "")
+		So(fs.Lookup("Cache-port").DefValue, ShouldEqual, "0")
+
+		_, err = RegisterStructFlags(flag.NewFlagSet("x", flag.ContinueOnError), 10, "-")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Struct fields are registered with their type", t, func() {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		l, err := RegisterStructFlags(fs, appConfig{Workers: 4, Ratio: 1, Tags: []string{"a", "b"}}, "-")
+		So(err, ShouldBeNil)
+		So(fs.Lookup("Workers").Value.(flag.Getter).Get(), ShouldEqual, uint64(4))
+		So(fs.Lookup("Ratio").Value.(flag.Getter).Get(), ShouldEqual, float64(1))
+		So(fs.Lookup("Tags").DefValue, ShouldEqual, "a,b")
+
+		So(fs.Parse([]string{"-Ratio", "0.5", "-Workers", "8"}), ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetFloat64("Ratio"), ShouldEqual, 0.5)
+		v, _ := o.Get("Workers")
+		So(v, ShouldEqual, uint64(8))
+
+		_, err = RegisterStructFlags(flag.NewFlagSet("x", flag.ContinueOnError), &struct {
+			Labels map[string]string
+		}{}, "-")
+		So(err, ShouldNotBeNil)
+	})
+}
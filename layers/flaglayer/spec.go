@@ -0,0 +1,14 @@
+package flaglayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	// The flags in the flag.CommandLine, so the flag.Parse should be called before
+	onion.RegisterLayerFactory(func(_ context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		return NewFlagLayer(nil, spec.Options.GetStringDefault("separator", "-"), spec.Options.GetBool("all")), nil
+	}, "flag")
+}
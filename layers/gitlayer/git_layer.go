@@ -0,0 +1,243 @@
+// Package gitlayer is a layer to read the config files from a git repository at a branch, tag
+// or commit. it reads the git objects directly, there is no need to the git binary.
+package gitlayer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/skarademir/naturalsort"
+)
+
+// Options is the git layer options
+type Options struct {
+	// Revision is the branch, tag or commit (full or short hash) to load, default is HEAD
+	Revision string
+	// Paths are the files or directories (slash separated, relative to the repository root) to
+	// load. all the files with a registered decoder in a directory are loaded in the natural sort
+	// order. the paths are merged in order, default is the root directory
+	Paths []string
+	// Cipher is used to decrypt the files, nil is accepted as plain
+	Cipher onion.Cipher
+	// Remote is the path to a local bare repository to read the revision from. it is not a git
+	// fetch: there is no network protocol, the refs are resolved in the remote and the objects
+	// are read from the remote when they are not in the local repository. nothing is copied and
+	// the local repository is not changed. to follow a network remote, keep a mirror up to date
+	// (like `git fetch` in a cron job) and use its path
+	Remote string
+	// Interval is the interval to resolve the revision again, zero means never. a commit hash
+	// never changes, so it is not resolved again
+	Interval time.Duration
+}
+
+// Layer is the git layer, Commit is the hash of the loaded commit, so it can be reported
+type Layer interface {
+	onion.Layer
+	Commit() string
+}
+
+type gitLayer struct {
+	opt     Options
+	refs    *repository
+	objects *repository
+
+	lock   sync.RWMutex
+	commit hash
+	data   map[string]interface{}
+	sender *onion.Sender
+}
+
+func (gl *gitLayer) Load() map[string]interface{} {
+	gl.lock.RLock()
+	defer gl.lock.RUnlock()
+
+	return gl.data
+}
+
+func (gl *gitLayer) Watch() <-chan map[string]interface{} {
+	return gl.sender.Watch()
+}
+
+func (gl *gitLayer) Commit() string {
+	gl.lock.RLock()
+	defer gl.lock.RUnlock()
+
+	return gl.commit.String()
+}
+
+// ReloadLayer resolves the revision again, and loads the files if the commit is changed
+func (gl *gitLayer) ReloadLayer(ctx context.Context) error {
+	commit, err := gl.refs.resolve(gl.opt.Revision)
+	if err != nil {
+		return err
+	}
+
+	gl.lock.RLock()
+	same := commit == gl.commit
+	gl.lock.RUnlock()
+	if same {
+		return nil
+	}
+
+	data, err := gl.read(ctx, commit)
+	if err != nil {
+		return err
+	}
+
+	gl.lock.Lock()
+	defer gl.lock.Unlock()
+
+	gl.commit, gl.data = commit, data
+	gl.sender.Send(data)
+	return nil
+}
+
+func (gl *gitLayer) decode(ctx context.Context, name string, blob hash) (map[string]interface{}, error) {
+	_, b, err := gl.objects.readObject(blob)
+	if err != nil {
+		return nil, err
+	}
+	l, err := onion.NewStreamLayerContext(ctx, bytes.NewReader(b), strings.TrimPrefix(path.Ext(name), "."), gl.opt.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	return l.Load(), nil
+}
+
+func (gl *gitLayer) read(ctx context.Context, commit hash) (map[string]interface{}, error) {
+	tree, err := gl.objects.commitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := gl.opt.Paths
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	var layersData []map[string]interface{}
+	for _, p := range paths {
+		entry, err := gl.objects.lookup(tree, p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !entry.isTree() {
+			data, err := gl.decode(ctx, p, entry.hash)
+			if err != nil {
+				return nil, err
+			}
+			layersData = append(layersData, data)
+			continue
+		}
+
+		entries, err := gl.objects.readTree(entry.hash)
+		if err != nil {
+			return nil, err
+		}
+		files := make(map[string]hash)
+		var names []string
+		for _, e := range entries {
+			ext := strings.TrimPrefix(path.Ext(e.name), ".")
+			if e.isTree() || ext == "" || onion.GetDecoder(ext) == nil {
+				continue
+			}
+			files[e.name] = e.hash
+			names = append(names, e.name)
+		}
+		sort.Sort(naturalsort.NaturalSort(names))
+
+		for _, name := range names {
+			data, err := gl.decode(ctx, name, files[name])
+			if err != nil {
+				return nil, err
+			}
+			layersData = append(layersData, data)
+		}
+	}
+
+	return onion.NewMapLayer(layersData...).Load(), nil
+}
+
+func (gl *gitLayer) fetch(ctx context.Context) {
+	ticker := time.NewTicker(gl.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := gl.ReloadLayer(ctx); err != nil {
+				log.Println("error:", err) // Better log support
+			}
+		}
+	}
+}
+
+// NewGitLayerContext creates a layer from the files in the git repository at the path (a working
+// tree or a bare repository) at the revision in the options. if the interval is set, the revision
+// is resolved again (from the remote if it is set) until the context is done, and the layer is
+// updated when the commit is changed. the pack files are closed when the context is done
+func NewGitLayerContext(ctx context.Context, repo string, opt Options) (Layer, error) {
+	local, err := openRepository(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	gl := &gitLayer{
+		opt:     opt,
+		refs:    local,
+		objects: local,
+		sender:  onion.NewSender(ctx),
+	}
+
+	if opt.Remote != "" {
+		remote, err := openRepository(opt.Remote)
+		if err != nil {
+			return nil, err
+		}
+		gl.refs = remote
+		local.objectDirs = append(local.objectDirs, remote.objectDirs...)
+	}
+
+	closeAll := func() {
+		local.close()
+		if gl.refs != local {
+			gl.refs.close()
+		}
+	}
+	if gl.commit, err = gl.refs.resolve(opt.Revision); err != nil {
+		closeAll()
+		return nil, err
+	}
+	if gl.data, err = gl.read(ctx, gl.commit); err != nil {
+		closeAll()
+		return nil, err
+	}
+	// The pack files are open until the context is done
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			closeAll()
+		}()
+	}
+
+	if _, pinned := parseHash(opt.Revision); opt.Interval > 0 && !pinned {
+		go gl.fetch(ctx)
+	}
+
+	return gl, nil
+}
+
+// NewGitLayer creates a new git layer, see NewGitLayerContext
+func NewGitLayer(repo string, opt Options) (Layer, error) {
+	return NewGitLayerContext(context.Background(), repo, opt)
+}
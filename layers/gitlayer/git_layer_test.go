@@ -0,0 +1,186 @@
+package gitlayer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// git runs the git binary, the layer does not need it, it is only used to build the test repository
+func git(dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=onion", "-c", "user.email=onion@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	So(err, ShouldBeNil)
+	return strings.TrimSpace(string(out))
+}
+
+func commitFiles(dir string, files map[string]string, msg string) string {
+	for name, content := range files {
+		So(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), ShouldBeNil)
+	}
+	git(dir, "add", "-A")
+	git(dir, "commit", "-q", "-m", msg)
+	return git(dir, "rev-parse", "HEAD")
+}
+
+func waitFor(o *onion.Onion, key string, value int) bool {
+	for i := 0; i < 100; i++ {
+		if o.GetInt(key) == value {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func isClosed(r *repository) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.closed
+}
+
+func TestNewGitLayer(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to create the test repository")
+	}
+
+	Convey("Load the files from a git repository", t, func() {
+		dir, err := ioutil.TempDir("", "onion-git-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		work := filepath.Join(dir, "work")
+		So(os.Mkdir(work, 0755), ShouldBeNil)
+		git(work, "init", "-q", "-b", "master")
+
+		first := commitFiles(work, map[string]string{
+			"conf/10-last.json": `{"version": 1, "last": true}`,
+			"conf/2-first.json": `{"version": 0, "first": true}`,
+			"conf/notes.txt":    "ignored",
+			"app.json":          `{"app": {"port": 8080}}`,
+		}, "first")
+		git(work, "tag", "-a", "v1", "-m", "release v1")
+
+		// Many versions of the same file, so the pack has deltas
+		commits := make(map[int]string)
+		for i := 2; i <= 5; i++ {
+			commits[i] = commitFiles(work, map[string]string{
+				"conf/10-last.json": fmt.Sprintf(`{"version": %d, "last": true, "padding": %q}`, i, strings.Repeat("onion ", 100)),
+			}, "next")
+		}
+		last := git(work, "rev-parse", "HEAD")
+
+		Convey("from the loose objects", func() {
+			l, err := NewGitLayer(work, Options{Revision: "v1", Paths: []string{"conf", "app.json"}})
+			So(err, ShouldBeNil)
+			So(l.Commit(), ShouldEqual, first)
+			o := onion.New(l)
+			So(o.GetInt("version"), ShouldEqual, 1)
+			So(o.GetBool("first"), ShouldBeTrue)
+			So(o.GetInt("app.port"), ShouldEqual, 8080)
+
+			l, err = NewGitLayer(work, Options{Paths: []string{"conf"}})
+			So(err, ShouldBeNil)
+			So(l.Commit(), ShouldEqual, last)
+			So(onion.New(l).GetInt("version"), ShouldEqual, 5)
+		})
+
+		Convey("from the packs", func() {
+			git(work, "gc", "-q", "--aggressive")
+			files, err := filepath.Glob(filepath.Join(work, ".git", "objects", "pack", "*.pack"))
+			So(err, ShouldBeNil)
+			So(files, ShouldNotBeEmpty)
+
+			revisions := map[string]int{first[:8]: 1, "master": 5}
+			for i := 2; i < 5; i++ {
+				revisions[commits[i]] = i
+			}
+			for rev, version := range revisions {
+				l, err := NewGitLayer(work, Options{Revision: rev, Paths: []string{"conf"}})
+				So(err, ShouldBeNil)
+				So(onion.New(l).GetInt("version"), ShouldEqual, version)
+			}
+
+			// The packs in use are not closed by a reload, only after the last release
+			r, err := openRepository(work)
+			So(err, ShouldBeNil)
+			old, err := r.acquirePacks(false)
+			So(err, ShouldBeNil)
+			current, err := r.acquirePacks(true)
+			So(err, ShouldBeNil)
+			r.releasePacks(current)
+			h, ok := parseHash(last)
+			So(ok, ShouldBeTrue)
+			off, ok := old[0].idx.find(h)
+			So(ok, ShouldBeTrue)
+			_, _, err = old[0].readAt(r, off, 0)
+			So(err, ShouldBeNil)
+			r.releasePacks(old)
+			_, _, err = old[0].readAt(r, off, 0)
+			So(err, ShouldNotBeNil)
+
+			r.close()
+			So(current[0].refs, ShouldEqual, 0)
+			_, _, err = r.readObject(h)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("invalid paths", func() {
+			_, err := NewGitLayer(work, Options{Paths: []string{"unknown"}})
+			So(err, ShouldNotBeNil)
+			_, err = NewGitLayer(work, Options{Paths: []string{"conf/notes.txt"}})
+			So(err, ShouldNotBeNil)
+			_, err = NewGitLayer(filepath.Join(work, "conf"), Options{})
+			So(err, ShouldNotBeNil)
+			_, err = NewGitLayer(work, Options{Remote: filepath.Join(dir, "unknown")})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("fetch from a bare remote", func() {
+			remote := filepath.Join(dir, "remote.git")
+			git(dir, "clone", "-q", "--bare", work, remote)
+			local := filepath.Join(dir, "local")
+			git(dir, "clone", "-q", remote, local)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			l, err := NewGitLayerContext(ctx, local, Options{
+				Revision: "master",
+				Paths:    []string{"conf"},
+				Remote:   remote,
+				Interval: 20 * time.Millisecond,
+			})
+			So(err, ShouldBeNil)
+			o := onion.NewContext(ctx, l)
+			So(o.GetInt("version"), ShouldEqual, 5)
+
+			next := commitFiles(work, map[string]string{"conf/10-last.json": `{"version": 6}`}, "next")
+			git(work, "push", "-q", remote, "master")
+			So(waitFor(o, "version", 6), ShouldBeTrue)
+			So(l.Commit(), ShouldEqual, next)
+
+			// The local repository is not changed
+			So(git(local, "rev-parse", "master"), ShouldEqual, last)
+
+			cancel()
+			gl := l.(*gitLayer)
+			for i := 0; i < 100 && !isClosed(gl.objects); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(isClosed(gl.objects), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,741 @@
+package gitlayer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+
+	maxDeltaDepth = 64
+	maxRefDepth   = 10
+	// maxObjectSize is the maximum size of an object, the size in the object header is not
+	// trusted before the read
+	maxObjectSize = 64 << 20
+)
+
+var (
+	// ErrNotRepository is returned when the path is not a git repository
+	ErrNotRepository = errors.New("not a git repository")
+	// ErrObjectNotFound is returned when the object is not in the repository
+	ErrObjectNotFound = errors.New("git object not found")
+	// ErrRevisionNotFound is returned when the revision can not be resolved
+	ErrRevisionNotFound = errors.New("git revision not found")
+)
+
+type hash [20]byte
+
+func (h hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+func parseHash(s string) (hash, bool) {
+	var h hash
+	if len(s) != 40 {
+		return h, false
+	}
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, false
+	}
+	return h, true
+}
+
+func isHex(s string) bool {
+	for i := range s {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+type treeEntry struct {
+	mode string
+	name string
+	hash hash
+}
+
+func (te treeEntry) isTree() bool {
+	return te.mode == "40000"
+}
+
+// repository is a minimal read only git object database, it supports the loose objects, the packs
+// (with deltas), the refs, packed refs and the alternates. there is no need to the git binary.
+type repository struct {
+	gitDir     string
+	objectDirs []string
+
+	lock   sync.Mutex
+	packs  []*pack
+	closed bool
+}
+
+func findGitDir(path string) (string, error) {
+	dotGit := filepath.Join(path, ".git")
+	st, err := os.Stat(dotGit)
+	switch {
+	case err == nil && st.IsDir():
+		return dotGit, nil
+	case err == nil:
+		// Worktrees and submodules have a .git file with the real path
+		b, err := ioutil.ReadFile(dotGit)
+		if err != nil {
+			return "", err
+		}
+		gitDir := strings.TrimSpace(strings.TrimPrefix(string(b), "gitdir:"))
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(path, gitDir)
+		}
+		return gitDir, nil
+	}
+
+	// Bare repository
+	if _, err := os.Stat(filepath.Join(path, "objects")); err != nil {
+		return "", ErrNotRepository
+	}
+	if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+		return "", ErrNotRepository
+	}
+	return path, nil
+}
+
+func openRepository(path string) (*repository, error) {
+	gitDir, err := findGitDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := filepath.Join(gitDir, "objects")
+	r := &repository{
+		gitDir:     gitDir,
+		objectDirs: []string{objects},
+	}
+
+	// The shared and the cloned with --reference repositories
+	if b, err := ioutil.ReadFile(filepath.Join(objects, "info", "alternates")); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if !filepath.IsAbs(line) {
+				line = filepath.Join(objects, line)
+			}
+			r.objectDirs = append(r.objectDirs, line)
+		}
+	}
+
+	return r, nil
+}
+
+// readRef reads a ref (like HEAD or refs/heads/master) from the loose refs and the packed refs
+func (r *repository) readRef(name string, depth int) (hash, error) {
+	if depth > maxRefDepth {
+		return hash{}, fmt.Errorf("too many symbolic refs for %q", name)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(r.gitDir, filepath.FromSlash(name))); err == nil {
+		content := strings.TrimSpace(string(b))
+		if strings.HasPrefix(content, "ref:") {
+			return r.readRef(strings.TrimSpace(strings.TrimPrefix(content, "ref:")), depth+1)
+		}
+		if h, ok := parseHash(content); ok {
+			return h, nil
+		}
+	}
+
+	f, err := os.Open(filepath.Join(r.gitDir, "packed-refs"))
+	if err != nil {
+		return hash{}, ErrRevisionNotFound
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) == 2 && parts[1] == name {
+			if h, ok := parseHash(parts[0]); ok {
+				return h, nil
+			}
+		}
+	}
+	return hash{}, ErrRevisionNotFound
+}
+
+// findPrefix finds the object with the short hash
+func (r *repository) findPrefix(prefix string) (hash, error) {
+	found := make(map[hash]bool)
+	for _, dir := range r.objectDirs {
+		entries, _ := ioutil.ReadDir(filepath.Join(dir, prefix[:2]))
+		for _, e := range entries {
+			if h, ok := parseHash(prefix[:2] + e.Name()); ok && strings.HasPrefix(h.String(), prefix) {
+				found[h] = true
+			}
+		}
+	}
+
+	packs, err := r.acquirePacks(false)
+	if err != nil {
+		return hash{}, err
+	}
+	defer r.releasePacks(packs)
+	for _, p := range packs {
+		for _, h := range p.idx.hashes {
+			if strings.HasPrefix(h.String(), prefix) {
+				found[h] = true
+			}
+		}
+	}
+
+	if len(found) != 1 {
+		return hash{}, ErrRevisionNotFound
+	}
+	for h := range found {
+		return h, nil
+	}
+	return hash{}, ErrRevisionNotFound
+}
+
+// resolve converts the revision (branch, tag, ref, full or short commit hash) into the commit hash
+func (r *repository) resolve(rev string) (hash, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	h, ok := parseHash(rev)
+	if !ok {
+		var err error = ErrRevisionNotFound
+		for _, name := range []string{
+			rev,
+			"refs/" + rev,
+			"refs/tags/" + rev,
+			"refs/heads/" + rev,
+			"refs/remotes/" + rev,
+			"refs/remotes/" + rev + "/HEAD",
+		} {
+			if h, err = r.readRef(name, 0); err == nil {
+				break
+			}
+		}
+		if err != nil && len(rev) >= 4 && isHex(rev) {
+			h, err = r.findPrefix(rev)
+		}
+		if err != nil {
+			return hash{}, fmt.Errorf("%w: %q", err, rev)
+		}
+	}
+
+	// Peel the annotated tags
+	for i := 0; i < maxRefDepth; i++ {
+		typ, data, err := r.readObject(h)
+		if err != nil {
+			return hash{}, err
+		}
+		switch typ {
+		case objCommit:
+			return h, nil
+		case objTag:
+			target, _ := header(data, "object")
+			next, ok := parseHash(target)
+			if !ok {
+				return hash{}, fmt.Errorf("invalid tag object %s", h)
+			}
+			h = next
+		default:
+			return hash{}, fmt.Errorf("%s is not a commit", h)
+		}
+	}
+	return hash{}, fmt.Errorf("too many nested tags for %q", rev)
+}
+
+// header returns the value of a header in a commit or tag object
+func header(data []byte, name string) (string, bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimPrefix(line, name+" "), true
+		}
+	}
+	return "", false
+}
+
+func (r *repository) openPacks() ([]*pack, error) {
+	var names []string
+	for _, dir := range r.objectDirs {
+		idx, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(idx)
+		names = append(names, idx...)
+	}
+
+	packs := make([]*pack, 0, len(names))
+	for _, name := range names {
+		p, err := openPack(name)
+		if err != nil {
+			for _, p := range packs {
+				p.unref()
+			}
+			return nil, err
+		}
+		packs = append(packs, p)
+	}
+	return packs, nil
+}
+
+// acquirePacks returns the packs, the packs are not closed until they are released with
+// releasePacks. on reload the packs are opened again and the old packs are closed after the
+// last release
+func (r *repository) acquirePacks(reload bool) ([]*pack, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil, errors.New("the repository is closed")
+	}
+	if r.packs == nil || reload {
+		packs, err := r.openPacks()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range r.packs {
+			p.unref()
+		}
+		r.packs = packs
+	}
+
+	for _, p := range r.packs {
+		p.refs++
+	}
+	return r.packs, nil
+}
+
+func (r *repository) releasePacks(packs []*pack) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, p := range packs {
+		p.unref()
+	}
+}
+
+// close closes the packs, the packs in use are closed after their last release
+func (r *repository) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, p := range r.packs {
+		p.unref()
+	}
+	r.packs, r.closed = nil, true
+}
+
+func (r *repository) readLoose(h hash) (int, []byte, error) {
+	s := h.String()
+	for _, dir := range r.objectDirs {
+		f, err := os.Open(filepath.Join(dir, s[:2], s[2:]))
+		if err != nil {
+			continue
+		}
+		defer func() { _ = f.Close() }()
+
+		zr, err := zlib.NewReader(f)
+		if err != nil {
+			return 0, nil, err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(zr, maxObjectSize+1))
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(b) > maxObjectSize {
+			return 0, nil, fmt.Errorf("object %s is too large", s)
+		}
+		nul := bytes.IndexByte(b, 0)
+		if nul < 0 {
+			return 0, nil, fmt.Errorf("invalid object %s", s)
+		}
+		var typ int
+		switch strings.SplitN(string(b[:nul]), " ", 2)[0] {
+		case "commit":
+			typ = objCommit
+		case "tree":
+			typ = objTree
+		case "blob":
+			typ = objBlob
+		case "tag":
+			typ = objTag
+		default:
+			return 0, nil, fmt.Errorf("invalid object type %q", b[:nul])
+		}
+		return typ, b[nul+1:], nil
+	}
+	return 0, nil, ErrObjectNotFound
+}
+
+func (r *repository) readObject(h hash) (int, []byte, error) {
+	typ, data, err := r.readLoose(h)
+	if err != ErrObjectNotFound {
+		return typ, data, err
+	}
+
+	for _, reload := range []bool{false, true} {
+		packs, err := r.acquirePacks(reload)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, p := range packs {
+			if off, ok := p.idx.find(h); ok {
+				typ, data, err := p.readAt(r, off, 0)
+				r.releasePacks(packs)
+				return typ, data, err
+			}
+		}
+		r.releasePacks(packs)
+	}
+	return 0, nil, fmt.Errorf("%w: %s", ErrObjectNotFound, h)
+}
+
+func (r *repository) readTree(h hash) ([]treeEntry, error) {
+	typ, data, err := r.readObject(h)
+	if err != nil {
+		return nil, err
+	}
+	if typ != objTree {
+		return nil, fmt.Errorf("%s is not a tree", h)
+	}
+
+	var res []treeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+21 {
+			return nil, fmt.Errorf("invalid tree %s", h)
+		}
+		te := treeEntry{
+			mode: string(data[:sp]),
+			name: string(data[sp+1 : nul]),
+		}
+		copy(te.hash[:], data[nul+1:nul+21])
+		res = append(res, te)
+		data = data[nul+21:]
+	}
+	return res, nil
+}
+
+// commitTree returns the root tree of the commit
+func (r *repository) commitTree(commit hash) (hash, error) {
+	typ, data, err := r.readObject(commit)
+	if err != nil {
+		return hash{}, err
+	}
+	if typ != objCommit {
+		return hash{}, fmt.Errorf("%s is not a commit", commit)
+	}
+	tree, _ := header(data, "tree")
+	h, ok := parseHash(tree)
+	if !ok {
+		return hash{}, fmt.Errorf("invalid commit %s", commit)
+	}
+	return h, nil
+}
+
+// lookup finds the entry of the slash separated path in the tree, empty path is the tree itself
+func (r *repository) lookup(tree hash, path string) (treeEntry, error) {
+	current := treeEntry{mode: "40000", hash: tree}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		if !current.isTree() {
+			return treeEntry{}, fmt.Errorf("%q is not a directory", path)
+		}
+		entries, err := r.readTree(current.hash)
+		if err != nil {
+			return treeEntry{}, err
+		}
+		found := false
+		for _, e := range entries {
+			if e.name == part {
+				current, found = e, true
+				break
+			}
+		}
+		if !found {
+			return treeEntry{}, fmt.Errorf("%q: %w", path, os.ErrNotExist)
+		}
+	}
+	return current, nil
+}
+
+type packIndex struct {
+	hashes  []hash
+	offsets []int64
+}
+
+func (pi *packIndex) find(h hash) (int64, bool) {
+	i := sort.Search(len(pi.hashes), func(i int) bool {
+		return bytes.Compare(pi.hashes[i][:], h[:]) >= 0
+	})
+	if i < len(pi.hashes) && pi.hashes[i] == h {
+		return pi.offsets[i], true
+	}
+	return 0, false
+}
+
+func readIndex(b []byte) (*packIndex, error) {
+	invalid := errors.New("invalid pack index")
+	if len(b) < 256*4 {
+		return nil, invalid
+	}
+
+	if !bytes.HasPrefix(b, []byte{0xff, 't', 'O', 'c'}) {
+		// Version 1: fanout table and then offset + hash for each object
+		n := int(binary.BigEndian.Uint32(b[255*4:]))
+		b = b[256*4:]
+		if len(b) < n*24 {
+			return nil, invalid
+		}
+		pi := &packIndex{hashes: make([]hash, n), offsets: make([]int64, n)}
+		for i := 0; i < n; i++ {
+			pi.offsets[i] = int64(binary.BigEndian.Uint32(b[i*24:]))
+			copy(pi.hashes[i][:], b[i*24+4:])
+		}
+		return pi, nil
+	}
+
+	if len(b) < 8+256*4 || binary.BigEndian.Uint32(b[4:]) != 2 {
+		return nil, invalid
+	}
+	n := int(binary.BigEndian.Uint32(b[8+255*4:]))
+	b = b[8+256*4:]
+	// hashes, crc32s, 4 byte offsets and then the 8 byte offsets
+	if len(b) < n*(20+4+4) {
+		return nil, invalid
+	}
+	pi := &packIndex{hashes: make([]hash, n), offsets: make([]int64, n)}
+	small := b[n*24:]
+	large := b[n*28:]
+	for i := 0; i < n; i++ {
+		copy(pi.hashes[i][:], b[i*20:])
+		off := binary.BigEndian.Uint32(small[i*4:])
+		if off&0x80000000 == 0 {
+			pi.offsets[i] = int64(off)
+			continue
+		}
+		li := int(off & 0x7fffffff)
+		if len(large) < (li+1)*8 {
+			return nil, invalid
+		}
+		pi.offsets[i] = int64(binary.BigEndian.Uint64(large[li*8:]))
+	}
+	return pi, nil
+}
+
+// pack is an open pack file, the refs is guarded by the repository lock. the repository holds one
+// reference until the packs are reloaded
+type pack struct {
+	idx  *packIndex
+	file *os.File
+	size int64
+	refs int
+}
+
+func openPack(idxPath string) (*pack, error) {
+	b, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := readIndex(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", idxPath, err)
+	}
+
+	f, err := os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &pack{idx: idx, file: f, size: st.Size(), refs: 1}, nil
+}
+
+// unref drops a reference, the file is closed with the last one
+func (p *pack) unref() {
+	if p.refs--; p.refs == 0 {
+		_ = p.file.Close()
+	}
+}
+
+// readAt reads the object at the offset in the pack, the deltas are resolved
+func (p *pack) readAt(r *repository, offset int64, depth int) (int, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, errors.New("delta chain is too long")
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(p.file, offset, p.size-offset))
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := int(b>>4) & 7
+	size := int64(b & 0x0f)
+	for shift := uint(4); b&0x80 != 0; shift += 7 {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(b&0x7f) << shift
+		if size < 0 || size > maxObjectSize {
+			return 0, nil, fmt.Errorf("pack object at %d is too large", offset)
+		}
+	}
+
+	var (
+		baseType int
+		base     []byte
+	)
+	switch typ {
+	case objCommit, objTree, objBlob, objTag:
+	case objOfsDelta:
+		if b, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = ((rel + 1) << 7) | int64(b&0x7f)
+		}
+		if baseType, base, err = p.readAt(r, offset-rel, depth+1); err != nil {
+			return 0, nil, err
+		}
+	case objRefDelta:
+		var h hash
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			return 0, nil, err
+		}
+		if baseType, base, err = r.readObject(h); err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("invalid pack object type %d", typ)
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return 0, nil, err
+	}
+
+	if base == nil && typ != objOfsDelta && typ != objRefDelta {
+		return typ, data, nil
+	}
+	res, err := applyDelta(base, data)
+	return baseType, res, err
+}
+
+func deltaSize(d []byte) (int, []byte, error) {
+	var size, shift uint
+	for i := range d {
+		size |= uint(d[i]&0x7f) << shift
+		shift += 7
+		if d[i]&0x80 == 0 {
+			return int(size), d[i+1:], nil
+		}
+	}
+	return 0, nil, errors.New("invalid delta")
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+	invalid := errors.New("invalid delta")
+	srcSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, invalid
+	}
+	dstSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if dstSize < 0 || dstSize > maxObjectSize {
+		return nil, invalid
+	}
+
+	res := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			var off, size int
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, invalid
+				}
+				if i < 4 {
+					off |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if off+size > len(base) {
+				return nil, invalid
+			}
+			res = append(res, base[off:off+size]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, invalid
+			}
+			res = append(res, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, invalid
+		}
+	}
+	if len(res) != dstSize {
+		return nil, invalid
+	}
+	return res, nil
+}
@@ -0,0 +1,138 @@
+package gitlayer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeObject(gitDir, typ string, content []byte) hash {
+	raw := append([]byte(fmt.Sprintf("%s %d\x00", typ, len(content))), content...)
+	h := hash(sha1.Sum(raw))
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write(raw)
+	_ = zw.Close()
+
+	s := h.String()
+	So(os.MkdirAll(filepath.Join(gitDir, "objects", s[:2]), 0755), ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(gitDir, "objects", s[:2], s[2:]), buf.Bytes(), 0644), ShouldBeNil)
+	return h
+}
+
+func treeContent(entries ...treeEntry) []byte {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e.mode + " " + e.name + "\x00")
+		buf.Write(e.hash[:])
+	}
+	return buf.Bytes()
+}
+
+func TestRepository(t *testing.T) {
+	Convey("Read the loose objects and the refs", t, func() {
+		dir, err := ioutil.TempDir("", "onion-git-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		gitDir := filepath.Join(dir, ".git")
+		blob := writeObject(gitDir, "blob", []byte(`{"a": 1}`))
+		sub := writeObject(gitDir, "tree", treeContent(treeEntry{mode: "100644", name: "b.json", hash: blob}))
+		tree := writeObject(gitDir, "tree", treeContent(
+			treeEntry{mode: "100644", name: "a.json", hash: blob},
+			treeEntry{mode: "40000", name: "sub", hash: sub},
+		))
+		commit := writeObject(gitDir, "commit", []byte("tree "+tree.String()+"\nauthor a <a> 0 +0000\n\ninit\n"))
+		tag := writeObject(gitDir, "tag", []byte("object "+commit.String()+"\ntype commit\ntag v1\n\nrelease\n"))
+
+		So(os.MkdirAll(filepath.Join(gitDir, "refs", "heads"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/master\n"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(gitDir, "refs", "heads", "master"), []byte(commit.String()+"\n"), 0644), ShouldBeNil)
+		packed := "# pack-refs with: peeled\n" + tag.String() + " refs/tags/v1\n^" + commit.String() + "\n"
+		So(ioutil.WriteFile(filepath.Join(gitDir, "packed-refs"), []byte(packed), 0644), ShouldBeNil)
+
+		r, err := openRepository(dir)
+		So(err, ShouldBeNil)
+
+		for _, rev := range []string{"", "HEAD", "master", "refs/heads/master", "v1", "tags/v1", commit.String(), commit.String()[:7]} {
+			h, err := r.resolve(rev)
+			So(err, ShouldBeNil)
+			So(h, ShouldEqual, commit)
+		}
+		_, err = r.resolve("unknown")
+		So(err, ShouldNotBeNil)
+		_, err = r.resolve(blob.String())
+		So(err, ShouldNotBeNil)
+		badTag := writeObject(gitDir, "tag", []byte("object invalid\ntype commit\ntag v2\n\nrelease\n"))
+		_, err = r.resolve(badTag.String())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "invalid tag object "+badTag.String())
+
+		root, err := r.commitTree(commit)
+		So(err, ShouldBeNil)
+		So(root, ShouldEqual, tree)
+
+		e, err := r.lookup(root, "sub/b.json")
+		So(err, ShouldBeNil)
+		So(e.hash, ShouldEqual, blob)
+		_, data, err := r.readObject(e.hash)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, `{"a": 1}`)
+
+		_, err = r.lookup(root, "sub/c.json")
+		So(errors.Is(err, os.ErrNotExist), ShouldBeTrue)
+		_, err = r.lookup(root, "a.json/b")
+		So(err, ShouldNotBeNil)
+
+		bare, err := openRepository(gitDir)
+		So(err, ShouldBeNil)
+		So(bare.gitDir, ShouldEqual, gitDir)
+
+		_, err = openRepository(filepath.Join(dir, "sub"))
+		So(err, ShouldEqual, ErrNotRepository)
+	})
+
+	Convey("Apply the deltas", t, func() {
+		base := []byte("hello world")
+		// source size, target size, copy 6 bytes from 0, insert "onion"
+		delta := []byte{11, 11, 0x80 | 0x10, 6, 5, 'o', 'n', 'i', 'o', 'n'}
+		res, err := applyDelta(base, delta)
+		So(err, ShouldBeNil)
+		So(string(res), ShouldEqual, "hello onion")
+
+		_, err = applyDelta(base, []byte{10, 11})
+		So(err, ShouldNotBeNil)
+		_, err = applyDelta(base, []byte{11, 11, 0x80 | 0x01 | 0x10, 10, 5})
+		So(err, ShouldNotBeNil)
+		_, err = applyDelta(base, []byte{11, 1, 0})
+		So(err, ShouldNotBeNil)
+		_, err = applyDelta(base, []byte{11, 0xff, 0xff, 0xff, 0xff, 0x7f})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Check the pack object size before the read", t, func() {
+		f, err := ioutil.TempFile("", "onion-pack-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.Remove(f.Name()) }()
+		defer func() { _ = f.Close() }()
+
+		// A blob with a huge size in the header and no data
+		header := []byte{0x30 | 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}
+		_, err = f.Write(header)
+		So(err, ShouldBeNil)
+
+		p := &pack{file: f, size: int64(len(header))}
+		_, _, err = p.readAt(nil, 0, 0)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "too large")
+	})
+}
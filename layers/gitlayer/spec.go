@@ -0,0 +1,20 @@
+package gitlayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		return NewGitLayerContext(ctx, opt.GetString("path"), Options{
+			Revision: opt.GetString("revision"),
+			Paths:    opt.GetStringSlice("paths"),
+			Cipher:   spec.Cipher,
+			Remote:   opt.GetString("remote"),
+			Interval: opt.GetDuration("interval"),
+		})
+	}, "git")
+}
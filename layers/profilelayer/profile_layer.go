@@ -0,0 +1,241 @@
+// Package profilelayer is a layer to stack the config files of the active profiles, like
+// config.yaml, config-prod.yaml and config-prod-eu.yaml
+package profilelayer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goraz/onion"
+)
+
+const (
+	// DefaultProfileEnv is the default environment variable with the active profiles
+	DefaultProfileEnv = "ONION_PROFILES"
+	// DefaultRegionEnv is the default environment variable with the region
+	DefaultRegionEnv = "ONION_REGION"
+	// DefaultSectionKey is the default key of the profile sections inside a document
+	DefaultSectionKey = "profiles"
+)
+
+// Options is the profile layer options
+type Options struct {
+	// Name is the base name of the files, default is config
+	Name string
+	// Profiles are the active profiles in order, the later profile overrides the previous one. if
+	// it is empty, the comma separated profiles in the ProfileEnv variable are used
+	Profiles []string
+	// ProfileEnv is the environment variable with the active profiles, default is ONION_PROFILES
+	ProfileEnv string
+	// Region is the region for the <name>-<profile>-<region> files, if it is empty the RegionEnv
+	// variable is used
+	Region string
+	// RegionEnv is the environment variable with the region, default is ONION_REGION
+	RegionEnv string
+	// Paths is the search path, the files in the later path overrides the same file in the previous
+	// paths. default is the current directory
+	Paths []string
+	// Extensions are the file extensions to search, only the extensions with a registered decoder
+	// are used. default is json, yaml, yml and toml
+	Extensions []string
+	// SectionKey is the key of the profile sections inside a document, the sections are merged
+	// over the document for the active profiles (and the <profile>-<region> sections). default is
+	// profiles
+	SectionKey string
+	// Cipher is used to decrypt the files, nil is accepted as plain
+	Cipher onion.Cipher
+}
+
+func (opt *Options) profiles() []string {
+	if len(opt.Profiles) > 0 {
+		return opt.Profiles
+	}
+	env := opt.ProfileEnv
+	if env == "" {
+		env = DefaultProfileEnv
+	}
+
+	var res []string
+	for _, p := range strings.Split(os.Getenv(env), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func (opt *Options) region() string {
+	if opt.Region != "" {
+		return opt.Region
+	}
+	env := opt.RegionEnv
+	if env == "" {
+		env = DefaultRegionEnv
+	}
+	return strings.TrimSpace(os.Getenv(env))
+}
+
+// names returns the base names of the files in order, the region files are after the profile files
+func (opt *Options) names() []string {
+	name := opt.Name
+	if name == "" {
+		name = "config"
+	}
+
+	res := []string{name}
+	for _, p := range opt.profiles() {
+		res = append(res, name+"-"+p)
+	}
+	if region := opt.region(); region != "" {
+		for _, p := range opt.profiles() {
+			res = append(res, name+"-"+p+"-"+region)
+		}
+	}
+	return res
+}
+
+// sections returns the profile section names in order
+func (opt *Options) sections() []string {
+	res := append([]string{}, opt.profiles()...)
+	if region := opt.region(); region != "" {
+		for _, p := range opt.profiles() {
+			res = append(res, p+"-"+region)
+		}
+	}
+	return res
+}
+
+// Files returns the existing files for the active profiles in the load order
+func (opt *Options) Files() []string {
+	paths := opt.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	exts := opt.Extensions
+	if len(exts) == 0 {
+		exts = []string{"json", "yaml", "yml", "toml"}
+	}
+
+	var res []string
+	for _, name := range opt.names() {
+		for _, dir := range paths {
+			for _, ext := range exts {
+				if onion.GetDecoder(ext) == nil {
+					continue
+				}
+				file := filepath.Join(dir, name+"."+ext)
+				if st, err := os.Stat(file); err == nil && st.Mode().IsRegular() {
+					res = append(res, file)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// normalize converts the map[interface{}]interface{} (from the yaml decoder) into the
+// map[string]interface{} so the maps are merged and not replaced
+func normalize(v interface{}) interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			res[k] = normalize(v)
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			res[fmt.Sprint(k)] = normalize(v)
+		}
+		return res
+	}
+	return v
+}
+
+func (opt *Options) load(ctx context.Context) (map[string]interface{}, error) {
+	sectionKey := opt.SectionKey
+	if sectionKey == "" {
+		sectionKey = DefaultSectionKey
+	}
+
+	var layersData []map[string]interface{}
+	for _, file := range opt.Files() {
+		l, err := onion.NewFileLayerContext(ctx, file, opt.Cipher)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		data, _ := normalize(l.Load()).(map[string]interface{})
+
+		sections, _ := data[sectionKey].(map[string]interface{})
+		delete(data, sectionKey)
+		layersData = append(layersData, data)
+		for _, s := range opt.sections() {
+			if section, ok := sections[s].(map[string]interface{}); ok {
+				layersData = append(layersData, section)
+			}
+		}
+	}
+
+	return onion.NewMapLayer(layersData...).Load(), nil
+}
+
+type profileLayer struct {
+	opt Options
+
+	lock   sync.RWMutex
+	data   map[string]interface{}
+	sender *onion.Sender
+}
+
+func (pl *profileLayer) Load() map[string]interface{} {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	return pl.data
+}
+
+func (pl *profileLayer) Watch() <-chan map[string]interface{} {
+	return pl.sender.Watch()
+}
+
+// ReloadLayer reads the active profiles (if they are from the environment) and the files again
+func (pl *profileLayer) ReloadLayer(ctx context.Context) error {
+	data, err := pl.opt.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	pl.data = data
+	pl.sender.Send(data)
+	return nil
+}
+
+// NewProfileLayerContext creates a layer from the files of the active profiles in the search path.
+// the files are merged in this order: <name>, <name>-<profile> for each profile and then
+// <name>-<profile>-<region> for each profile. the missing files are ignored. inside each file, the
+// sections under the "profiles" key are merged over the file in the same order
+func NewProfileLayerContext(ctx context.Context, opt Options) (onion.Layer, error) {
+	data, err := opt.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &profileLayer{
+		opt:    opt,
+		data:   data,
+		sender: onion.NewSender(ctx),
+	}, nil
+}
+
+// NewProfileLayer creates a new profile layer, see NewProfileLayerContext
+func NewProfileLayer(opt Options) (onion.Layer, error) {
+	return NewProfileLayerContext(context.Background(), opt)
+}
@@ -0,0 +1,105 @@
+package profilelayer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goraz/onion"
+	_ "github.com/goraz/onion/loaders/yaml"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeFiles(dir string, files map[string]string) {
+	for name, content := range files {
+		So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), ShouldBeNil)
+	}
+}
+
+func TestNewProfileLayer(t *testing.T) {
+	Convey("Stack the files of the active profiles", t, func() {
+		base, err := ioutil.TempDir("", "onion-profile-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(base) }()
+		etc, local := filepath.Join(base, "etc"), filepath.Join(base, "local")
+		So(os.Mkdir(etc, 0755), ShouldBeNil)
+		So(os.Mkdir(local, 0755), ShouldBeNil)
+
+		writeFiles(etc, map[string]string{
+			"config.yaml": `
+db:
+  host: localhost
+  port: 5432
+log: debug
+profiles:
+  prod:
+    log: warn
+  prod-eu:
+    log: error
+`,
+			"config-prod.yaml":    "db:\n  host: prod-db\n",
+			"config-prod-eu.json": `{"db": {"host": "eu-db"}}`,
+			"config-staging.yaml": "db:\n  host: staging-db\n",
+			"config-prod.txt":     "ignored",
+		})
+		writeFiles(local, map[string]string{
+			"config.yaml": "db:\n  port: 5433\n",
+		})
+
+		opt := Options{Paths: []string{etc, local}}
+
+		l, err := NewProfileLayer(opt)
+		So(err, ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetString("db.host"), ShouldEqual, "localhost")
+		So(o.GetInt("db.port"), ShouldEqual, 5433)
+		So(o.GetString("log"), ShouldEqual, "debug")
+		_, ok := o.Get("profiles")
+		So(ok, ShouldBeFalse)
+
+		opt.Profiles = []string{"staging", "prod"}
+		So(opt.Files(), ShouldResemble, []string{
+			filepath.Join(etc, "config.yaml"),
+			filepath.Join(local, "config.yaml"),
+			filepath.Join(etc, "config-staging.yaml"),
+			filepath.Join(etc, "config-prod.yaml"),
+		})
+		l, err = NewProfileLayer(opt)
+		So(err, ShouldBeNil)
+		o = onion.New(l)
+		So(o.GetString("db.host"), ShouldEqual, "prod-db")
+		So(o.GetInt("db.port"), ShouldEqual, 5433)
+		So(o.GetString("log"), ShouldEqual, "warn")
+
+		Convey("profiles and region from the environment", func() {
+			So(os.Setenv("TEST_PROFILES", " prod, "), ShouldBeNil)
+			So(os.Setenv("TEST_REGION", "eu"), ShouldBeNil)
+			defer func() {
+				_ = os.Unsetenv("TEST_PROFILES")
+				_ = os.Unsetenv("TEST_REGION")
+			}()
+
+			l, err := NewProfileLayer(Options{Paths: []string{etc}, ProfileEnv: "TEST_PROFILES", RegionEnv: "TEST_REGION"})
+			So(err, ShouldBeNil)
+			o := onion.New(l)
+			So(o.GetString("db.host"), ShouldEqual, "eu-db")
+			So(o.GetString("log"), ShouldEqual, "error")
+
+			So(os.Setenv("TEST_PROFILES", "staging"), ShouldBeNil)
+			watch := o.ReloadWatch()
+			res := o.Reload(context.Background())
+			So(res[0].Err, ShouldBeNil)
+			<-watch
+			So(o.GetString("db.host"), ShouldEqual, "staging-db")
+			So(o.GetString("log"), ShouldEqual, "debug")
+		})
+
+		Convey("invalid files", func() {
+			writeFiles(local, map[string]string{"config-prod.json": "invalid"})
+			_, err := NewProfileLayer(opt)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,24 @@
+package profilelayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		return NewProfileLayerContext(ctx, Options{
+			Name:       opt.GetString("name"),
+			Profiles:   opt.GetStringSlice("profiles"),
+			ProfileEnv: opt.GetString("profile_env"),
+			Region:     opt.GetString("region"),
+			RegionEnv:  opt.GetString("region_env"),
+			Paths:      opt.GetStringSlice("paths"),
+			Extensions: opt.GetStringSlice("extensions"),
+			SectionKey: opt.GetString("section_key"),
+			Cipher:     spec.Cipher,
+		})
+	}, "profile")
+}
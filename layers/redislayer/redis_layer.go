@@ -0,0 +1,277 @@
+// Package redislayer is a layer to load the config from a redis hash or string key, it watches the
+// key using the keyspace notifications or a pub/sub channel
+package redislayer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/maputil"
+)
+
+const (
+	maxBackoff     = 30 * time.Second
+	defaultTimeout = 5 * time.Second
+)
+
+// Options is the redis layer options
+type Options struct {
+	// Address is the redis server address, like localhost:6379
+	Address string
+	// Username and Password are used for AUTH, if the password is not empty
+	Username string
+	Password string
+	// DB is the database number
+	DB int
+	// Key is a hash (each field is a key, split by the separator) or a string encoded in the format
+	Key string
+	// Separator is the separator of the nested keys in the hash fields, default is "."
+	Separator string
+	// Format is the format of the string key, it should be a registered format, default is json
+	Format string
+	// Cipher is used to decrypt the string key, nil is accepted as plain
+	Cipher onion.Cipher
+	// Channel is the pub/sub channel to reload the key on each message, if it is empty the keyspace
+	// notifications of the key are used (notify-keyspace-events should be enabled on the server)
+	Channel string
+	// Timeout is the timeout of the connect and of each command, default is 5 seconds
+	Timeout time.Duration
+}
+
+func (opt *Options) timeout() time.Duration {
+	if opt.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return opt.Timeout
+}
+
+type redisLayer struct {
+	opt Options
+
+	cmdLock sync.Mutex
+	cmd     *conn
+
+	lock   sync.RWMutex
+	data   map[string]interface{}
+	sender *onion.Sender
+}
+
+func (rl *redisLayer) Load() map[string]interface{} {
+	rl.lock.RLock()
+	defer rl.lock.RUnlock()
+
+	return rl.data
+}
+
+func (rl *redisLayer) Watch() <-chan map[string]interface{} {
+	return rl.sender.Watch()
+}
+
+// ReloadLayer reads the key again and sends it to the watch channel
+func (rl *redisLayer) ReloadLayer(ctx context.Context) error {
+	data, err := rl.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.data = data
+	rl.sender.Send(data)
+	return nil
+}
+
+func (rl *redisLayer) do(ctx context.Context, args ...string) (interface{}, error) {
+	// A kept connection may be closed by the server, so try once more with a new connection
+	for retry := rl.cmd != nil; ; retry = false {
+		if rl.cmd == nil {
+			c, err := dial(ctx, &rl.opt)
+			if err != nil {
+				return nil, err
+			}
+			rl.cmd = c
+		}
+
+		res, err := rl.cmd.do(ctx, args...)
+		if _, ok := err.(redisError); err == nil || ok {
+			return res, err
+		}
+		_ = rl.cmd.Close()
+		rl.cmd = nil
+		if !retry {
+			return nil, err
+		}
+	}
+}
+
+// close closes the kept connection of the commands
+func (rl *redisLayer) close() {
+	rl.cmdLock.Lock()
+	defer rl.cmdLock.Unlock()
+
+	if rl.cmd != nil {
+		_ = rl.cmd.Close()
+		rl.cmd = nil
+	}
+}
+
+func (rl *redisLayer) read(ctx context.Context) (map[string]interface{}, error) {
+	rl.cmdLock.Lock()
+	defer rl.cmdLock.Unlock()
+
+	typ, err := rl.do(ctx, "TYPE", rl.opt.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch str(typ) {
+	case "none":
+		return nil, nil
+	case "string":
+		v, err := rl.do(ctx, "GET", rl.opt.Key)
+		if err != nil {
+			return nil, err
+		}
+		b, _ := v.([]byte)
+		if b == nil {
+			// Removed after the TYPE
+			return nil, nil
+		}
+		format := rl.opt.Format
+		if format == "" {
+			format = "json"
+		}
+		l, err := onion.NewStreamLayerContext(ctx, bytes.NewReader(b), format, rl.opt.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		return l.Load(), nil
+	case "hash":
+		v, err := rl.do(ctx, "HGETALL", rl.opt.Key)
+		if err != nil {
+			return nil, err
+		}
+		fields, _ := v.([]interface{})
+		sep := rl.opt.Separator
+		if sep == "" {
+			sep = "."
+		}
+		var data map[string]interface{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			data = maputil.Build(data, str(fields[i+1]), strings.Split(str(fields[i]), sep)...)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("key %q is a %s, only hash and string are supported", rl.opt.Key, str(typ))
+}
+
+// subscribe subscribes to the channel and reloads on each message until the connection is broken
+func (rl *redisLayer) subscribe(ctx context.Context, reload bool) (bool, error) {
+	c, err := dial(ctx, &rl.opt)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = c.Close()
+	}()
+
+	channel := rl.opt.Channel
+	if channel == "" {
+		channel = "__keyspace@" + strconv.Itoa(rl.opt.DB) + "__:" + rl.opt.Key
+	}
+	if _, err := c.do(ctx, "SUBSCRIBE", channel); err != nil {
+		return false, err
+	}
+	// The messages are waited for without a deadline
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return false, err
+	}
+
+	// The changes while the connection was broken are lost, so load the key again
+	if reload {
+		if err := rl.ReloadLayer(ctx); err != nil {
+			log.Println("error:", err) // Better log support
+		}
+	}
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return true, err
+		}
+		if parts, ok := msg.([]interface{}); !ok || len(parts) != 3 || str(parts[0]) != "message" {
+			continue
+		}
+		if err := rl.ReloadLayer(ctx); err != nil {
+			log.Println("error:", err) // Better log support
+		}
+	}
+}
+
+func (rl *redisLayer) watch(ctx context.Context) {
+	backoff := time.Second
+	reload := false
+	for {
+		subscribed, err := rl.subscribe(ctx, reload)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("error:", err) // Better log support
+
+		reload = true
+		if subscribed {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// NewRedisLayerContext creates a layer from a redis hash or string key, the layer reloads the key
+// on the keyspace notifications (or the messages in the channel) until the context is done. the
+// broken connections are reconnected with backoff
+func NewRedisLayerContext(ctx context.Context, opt Options) (onion.Layer, error) {
+	rl := &redisLayer{
+		opt:    opt,
+		sender: onion.NewSender(ctx),
+	}
+
+	var err error
+	if rl.data, err = rl.read(ctx); err != nil {
+		rl.close()
+		return nil, err
+	}
+
+	go rl.watch(ctx)
+	go func() {
+		<-ctx.Done()
+		rl.close()
+	}()
+
+	return rl, nil
+}
+
+// NewRedisLayer creates a new redis layer, see NewRedisLayerContext
+func NewRedisLayer(opt Options) (onion.Layer, error) {
+	return NewRedisLayerContext(context.Background(), opt)
+}
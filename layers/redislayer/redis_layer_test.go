@@ -0,0 +1,289 @@
+package redislayer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRedis is an in-process RESP server with the commands used by the layer, the changes are
+// published to the keyspace channel of the key
+type fakeRedis struct {
+	ln net.Listener
+
+	lock        sync.Mutex
+	strings     map[string]string
+	hashes      map[string]map[string]string
+	subscribers map[string][]*conn
+	conns       []net.Conn
+	// open is the number of the connections not closed by the client
+	open int
+}
+
+func newFakeRedis() *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	fr := &fakeRedis{
+		ln:          ln,
+		strings:     make(map[string]string),
+		hashes:      make(map[string]map[string]string),
+		subscribers: make(map[string][]*conn),
+	}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fr.lock.Lock()
+			fr.conns = append(fr.conns, nc)
+			fr.lock.Unlock()
+			go fr.serve(&conn{Conn: nc, r: bufio.NewReader(nc)})
+		}
+	}()
+	return fr
+}
+
+func (fr *fakeRedis) reply(c *conn, v interface{}) {
+	switch r := v.(type) {
+	case nil:
+		_, _ = c.Write([]byte("$-1\r\n"))
+	case redisError:
+		_, _ = c.Write([]byte("-" + string(r) + "\r\n"))
+	case string:
+		_, _ = c.Write([]byte("+" + r + "\r\n"))
+	case []byte:
+		_, _ = fmt.Fprintf(c, "$%d\r\n%s\r\n", len(r), r)
+	case []string:
+		_ = c.send(r...)
+	}
+}
+
+func (fr *fakeRedis) serve(c *conn) {
+	fr.lock.Lock()
+	fr.open++
+	fr.lock.Unlock()
+	defer func() {
+		_ = c.Close()
+		fr.lock.Lock()
+		fr.open--
+		fr.lock.Unlock()
+	}()
+	for {
+		v, err := c.read()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range v.([]interface{}) {
+			args = append(args, str(a))
+		}
+
+		fr.lock.Lock()
+		var res interface{}
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			res = redisError("ERR invalid password")
+			if args[len(args)-1] == "secret" {
+				res = "OK"
+			}
+		case "SELECT":
+			res = "OK"
+		case "TYPE":
+			res = "none"
+			if _, ok := fr.strings[args[1]]; ok {
+				res = "string"
+			}
+			if _, ok := fr.hashes[args[1]]; ok {
+				res = "hash"
+			}
+		case "GET":
+			if s, ok := fr.strings[args[1]]; ok {
+				res = []byte(s)
+			}
+		case "HGETALL":
+			var fields []string
+			for k, v := range fr.hashes[args[1]] {
+				fields = append(fields, k, v)
+			}
+			res = fields
+		case "SUBSCRIBE":
+			fr.subscribers[args[1]] = append(fr.subscribers[args[1]], c)
+			res = []string{"subscribe", args[1], "1"}
+		default:
+			res = redisError("ERR unknown command")
+		}
+		fr.lock.Unlock()
+		fr.reply(c, res)
+	}
+}
+
+func (fr *fakeRedis) publish(channel, msg string) {
+	for _, c := range fr.subscribers[channel] {
+		_ = c.send("message", channel, msg)
+	}
+}
+
+func (fr *fakeRedis) hset(key, field, value string) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	if fr.hashes[key] == nil {
+		fr.hashes[key] = make(map[string]string)
+	}
+	fr.hashes[key][field] = value
+	fr.publish("__keyspace@2__:"+key, "hset")
+}
+
+func (fr *fakeRedis) set(key, value, channel string) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	fr.strings[key] = value
+	fr.publish(channel, "changed")
+}
+
+// drop closes all the connections, like a server restart
+func (fr *fakeRedis) drop() {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	for _, c := range fr.conns {
+		_ = c.Close()
+	}
+	fr.conns = nil
+	fr.subscribers = make(map[string][]*conn)
+}
+
+func (fr *fakeRedis) subscribed(channel string) bool {
+	for i := 0; i < 300; i++ {
+		fr.lock.Lock()
+		n := len(fr.subscribers[channel])
+		fr.lock.Unlock()
+		if n > 0 {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// waitOpen waits for the number of the open connections
+func (fr *fakeRedis) waitOpen(n int) bool {
+	for i := 0; i < 300; i++ {
+		fr.lock.Lock()
+		open := fr.open
+		fr.lock.Unlock()
+		if open == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func waitFor(o *onion.Onion, key string, value string) bool {
+	for i := 0; i < 300; i++ {
+		if v, _ := o.Get(key); fmt.Sprint(v) == value {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestNewRedisLayer(t *testing.T) {
+	Convey("Load a hash and watch the keyspace notifications", t, func() {
+		fr := newFakeRedis()
+		defer func() { _ = fr.ln.Close(); fr.drop() }()
+		fr.hset("config", "db.host", "localhost")
+		fr.hset("config", "db.port", "5432")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		opt := Options{Address: fr.ln.Addr().String(), Password: "secret", DB: 2, Key: "config"}
+		l, err := NewRedisLayerContext(ctx, opt)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetString("db.host"), ShouldEqual, "localhost")
+		So(o.GetInt("db.port"), ShouldEqual, 5432)
+
+		So(fr.subscribed("__keyspace@2__:config"), ShouldBeTrue)
+		fr.hset("config", "db.host", "db.local")
+		So(waitFor(o, "db.host", "db.local"), ShouldBeTrue)
+
+		Convey("reconnect after the connection is broken", func() {
+			fr.drop()
+			fr.lock.Lock()
+			fr.hashes["config"]["db.host"] = "changed.while.down"
+			fr.lock.Unlock()
+
+			So(waitFor(o, "db.host", "changed.while.down"), ShouldBeTrue)
+			So(fr.subscribed("__keyspace@2__:config"), ShouldBeTrue)
+			fr.hset("config", "db.port", "5433")
+			So(waitFor(o, "db.port", "5433"), ShouldBeTrue)
+		})
+
+		Convey("invalid options", func() {
+			// The command and the subscribe connections of the layer
+			So(fr.waitOpen(2), ShouldBeTrue)
+			_, err := NewRedisLayerContext(ctx, Options{Address: opt.Address, Password: "invalid", Key: "config"})
+			So(err, ShouldNotBeNil)
+			fr.set("config-yaml", "a: b", "")
+			_, err = NewRedisLayerContext(ctx, Options{Address: opt.Address, Key: "config-yaml", Format: "yaml"})
+			So(err, ShouldNotBeNil)
+			So(fr.waitOpen(2), ShouldBeTrue)
+		})
+	})
+
+	Convey("Load a json string and watch a channel", t, func() {
+		fr := newFakeRedis()
+		defer func() { _ = fr.ln.Close(); fr.drop() }()
+		fr.set("config", `{"rate": {"limit": 10}}`, "")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		l, err := NewRedisLayerContext(ctx, Options{Address: fr.ln.Addr().String(), Key: "config", Channel: "config-changed"})
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetInt("rate.limit"), ShouldEqual, 10)
+
+		So(fr.subscribed("config-changed"), ShouldBeTrue)
+		fr.set("config", `{"rate": {"limit": 5}}`, "config-changed")
+		So(waitFor(o, "rate.limit", "5"), ShouldBeTrue)
+
+		_, err = NewRedisLayerContext(ctx, Options{Address: fr.ln.Addr().String(), Key: "missing"})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Timeout on a server without reply", t, func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = ln.Close() }()
+		go func() {
+			for {
+				nc, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer func() { _ = nc.Close() }()
+			}
+		}()
+
+		start := time.Now()
+		_, err = NewRedisLayer(Options{Address: ln.Addr().String(), Key: "config", Timeout: 50 * time.Millisecond})
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
@@ -0,0 +1,139 @@
+package redislayer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisError is an error reply from the server
+type redisError string
+
+func (re redisError) Error() string {
+	return string(re)
+}
+
+// conn is a minimal RESP connection, only the commands needed by the layer are used
+type conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func dial(ctx context.Context, opt *Options) (*conn, error) {
+	d := net.Dialer{Timeout: opt.timeout()}
+	nc, err := d.DialContext(ctx, "tcp", opt.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), timeout: opt.timeout()}
+	if opt.Password != "" {
+		args := []string{"AUTH", opt.Password}
+		if opt.Username != "" {
+			args = []string{"AUTH", opt.Username, opt.Password}
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	if opt.DB != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(opt.DB)); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *conn) send(args ...string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.Conn, sb.String())
+	return err
+}
+
+// setDeadline sets the deadline of the next command, the context deadline is used if it is earlier
+func (c *conn) setDeadline(ctx context.Context) error {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return c.SetDeadline(deadline)
+}
+
+func (c *conn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := c.setDeadline(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads a reply, the simple strings are string, the bulk strings are []byte (nil for the null
+// bulk string), integers are int64 and arrays are []interface{}
+func (c *conn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("invalid reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		res := make([]interface{}, n)
+		for i := range res {
+			if res[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("invalid reply type %q", kind)
+}
+
+// str converts a simple or bulk string reply into string
+func str(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
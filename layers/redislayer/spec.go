@@ -0,0 +1,25 @@
+package redislayer
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		return NewRedisLayerContext(ctx, Options{
+			Address:   opt.GetString("address"),
+			Username:  opt.GetString("username"),
+			Password:  opt.GetString("password"),
+			DB:        opt.GetInt("db"),
+			Key:       opt.GetString("key"),
+			Separator: opt.GetString("separator"),
+			Format:    opt.GetString("format"),
+			Cipher:    spec.Cipher,
+			Channel:   opt.GetString("channel"),
+			Timeout:   opt.GetDuration("timeout"),
+		})
+	}, "redis")
+}
@@ -0,0 +1,37 @@
+package sqllayer
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	// The driver should be imported by the application, like the sql.Open
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		db, err := sql.Open(opt.GetString("driver"), opt.GetString("dsn"))
+		if err != nil {
+			return nil, err
+		}
+
+		var args []interface{}
+		for _, a := range opt.GetStringSlice("args") {
+			args = append(args, a)
+		}
+		// The db is owned by the layer, so it is closed with the context
+		l, err := newSQLLayer(ctx, db, Options{
+			Query:        opt.GetString("query"),
+			Args:         args,
+			Separator:    opt.GetString("separator"),
+			VersionQuery: opt.GetString("version_query"),
+			Interval:     opt.GetDuration("interval"),
+		}, true)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return l, nil
+	}, "sql")
+}
@@ -0,0 +1,251 @@
+// Package sqllayer is a layer to load the config from a database table using database/sql
+package sqllayer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goraz/onion"
+)
+
+// Options is the sql layer options
+type Options struct {
+	// Query returns the rows of (key, value) or (key, value, type). the type is one of string (or
+	// empty, or NULL), int, float, bool, duration and json. the rows with NULL value are ignored
+	Query string
+	// Args are the query arguments, like the tenant id
+	Args []interface{}
+	// Separator is the separator of the nested keys in the key column, default is "."
+	Separator string
+	// VersionQuery returns a single value (like max(updated_at) or a version column), if it is set
+	// the rows are loaded again only if the version is changed
+	VersionQuery string
+	// VersionArgs are the version query arguments
+	VersionArgs []interface{}
+	// Interval is the interval to check the table again, zero means no refresh
+	Interval time.Duration
+}
+
+type sqlLayer struct {
+	db  *sql.DB
+	opt Options
+	// closeDB is set when the layer owns the db, it is closed when the context is done
+	closeDB bool
+
+	lock    sync.RWMutex
+	version interface{}
+	data    map[string]interface{}
+	sender  *onion.Sender
+}
+
+func (sl *sqlLayer) Load() map[string]interface{} {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	return sl.data
+}
+
+func (sl *sqlLayer) Watch() <-chan map[string]interface{} {
+	return sl.sender.Watch()
+}
+
+// ReloadLayer checks the version (if there is a version query) and reads the rows again, the new
+// data is sent to the watch channel if it is changed
+func (sl *sqlLayer) ReloadLayer(ctx context.Context) error {
+	version, err := sl.readVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	sl.lock.RLock()
+	same := sl.opt.VersionQuery != "" && reflect.DeepEqual(version, sl.version)
+	sl.lock.RUnlock()
+	if same {
+		return nil
+	}
+
+	data, err := sl.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	sl.version = version
+	if reflect.DeepEqual(data, sl.data) {
+		return nil
+	}
+	sl.data = data
+	sl.sender.Send(data)
+	return nil
+}
+
+func (sl *sqlLayer) readVersion(ctx context.Context) (interface{}, error) {
+	if sl.opt.VersionQuery == "" {
+		return nil, nil
+	}
+
+	var version interface{}
+	if err := sl.db.QueryRowContext(ctx, sl.opt.VersionQuery, sl.opt.VersionArgs...).Scan(&version); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func convert(value, typ string) (interface{}, error) {
+	switch strings.ToLower(typ) {
+	case "", "string", "str", "text":
+		return value, nil
+	case "int", "integer":
+		return strconv.ParseInt(value, 10, 64)
+	case "float", "number":
+		return strconv.ParseFloat(value, 64)
+	case "bool", "boolean":
+		return strconv.ParseBool(value)
+	case "duration":
+		return time.ParseDuration(value)
+	case "json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid type %q", typ)
+}
+
+func (sl *sqlLayer) read(ctx context.Context) (map[string]interface{}, error) {
+	rows, err := sl.db.QueryContext(ctx, sl.opt.Query, sl.opt.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) != 2 && len(columns) != 3 {
+		return nil, fmt.Errorf("the query should return 2 or 3 columns, got %d", len(columns))
+	}
+
+	sep := sl.opt.Separator
+	if sep == "" {
+		sep = "."
+	}
+
+	data := make(map[string]interface{})
+	for rows.Next() {
+		var (
+			key        string
+			value, typ sql.NullString
+			dest       = []interface{}{&key, &value, &typ}
+		)
+		if err := rows.Scan(dest[:len(columns)]...); err != nil {
+			return nil, err
+		}
+		if !value.Valid {
+			continue
+		}
+
+		v, err := convert(value.String, typ.String)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+		if err := set(data, v, strings.Split(key, sep), sep); err != nil {
+			return nil, err
+		}
+	}
+	return data, rows.Err()
+}
+
+// set sets the value in the nested map, a key can not be both a value and a parent of other keys
+func set(m map[string]interface{}, v interface{}, path []string, sep string) error {
+	for i, k := range path[:len(path)-1] {
+		next, ok := m[k]
+		if !ok {
+			next = make(map[string]interface{})
+			m[k] = next
+		}
+		if m, ok = next.(map[string]interface{}); !ok {
+			return fmt.Errorf("key %q is not a map", strings.Join(path[:i+1], sep))
+		}
+	}
+
+	last := path[len(path)-1]
+	if _, ok := m[last].(map[string]interface{}); ok {
+		return fmt.Errorf("key %q is a map", strings.Join(path, sep))
+	}
+	m[last] = v
+	return nil
+}
+
+// run refreshes the layer at every interval (if it is set) and closes the db if the layer owns it,
+// until the context is done
+func (sl *sqlLayer) run(ctx context.Context) {
+	if sl.closeDB {
+		defer func() { _ = sl.db.Close() }()
+	}
+	if sl.opt.Interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(sl.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sl.ReloadLayer(ctx); err != nil {
+				log.Println("error:", err) // Better log support
+			}
+		}
+	}
+}
+
+// NewSQLLayerContext creates a layer from the rows of the query, the key column is split by the
+// separator into the nested keys. if the interval is set, the table is checked again until the
+// context is done
+func NewSQLLayerContext(ctx context.Context, db *sql.DB, opt Options) (onion.Layer, error) {
+	return newSQLLayer(ctx, db, opt, false)
+}
+
+func newSQLLayer(ctx context.Context, db *sql.DB, opt Options, closeDB bool) (onion.Layer, error) {
+	sl := &sqlLayer{
+		db:      db,
+		opt:     opt,
+		closeDB: closeDB,
+		sender:  onion.NewSender(ctx),
+	}
+
+	var err error
+	if sl.version, err = sl.readVersion(ctx); err != nil {
+		return nil, err
+	}
+	if sl.data, err = sl.read(ctx); err != nil {
+		return nil, err
+	}
+
+	// The background context is never done, so the owned db is used for the life of the program
+	if opt.Interval > 0 || (closeDB && ctx.Done() != nil) {
+		go sl.run(ctx)
+	}
+
+	return sl, nil
+}
+
+// NewSQLLayer creates a new sql layer, see NewSQLLayerContext
+func NewSQLLayer(db *sql.DB, opt Options) (onion.Layer, error) {
+	return NewSQLLayerContext(context.Background(), db, opt)
+}
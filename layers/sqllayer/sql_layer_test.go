@@ -0,0 +1,267 @@
+package sqllayer
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeTable is a table for the fake driver, the config query returns the rows and the version
+// query returns the version
+type fakeTable struct {
+	lock    sync.Mutex
+	columns []string
+	rows    [][]driver.Value
+	version int64
+	closed  int
+}
+
+func (ft *fakeTable) closedConns() int {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	return ft.closed
+}
+
+func (ft *fakeTable) set(version int64, rows ...[]driver.Value) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	ft.version, ft.rows = version, rows
+}
+
+var tables = struct {
+	sync.Mutex
+	m map[string]*fakeTable
+}{m: make(map[string]*fakeTable)}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	tables.Lock()
+	defer tables.Unlock()
+
+	t, ok := tables.m[name]
+	if !ok {
+		return nil, errors.New("unknown table")
+	}
+	return &fakeConn{table: t}, nil
+}
+
+type fakeConn struct {
+	table *fakeTable
+}
+
+func (fc *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{table: fc.table, query: query}, nil
+}
+
+func (fc *fakeConn) Close() error {
+	fc.table.lock.Lock()
+	defer fc.table.lock.Unlock()
+
+	fc.table.closed++
+	return nil
+}
+
+func (fc *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	table *fakeTable
+	query string
+}
+
+func (fs *fakeStmt) Close() error  { return nil }
+func (fs *fakeStmt) NumInput() int { return -1 }
+
+func (fs *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fs *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	fs.table.lock.Lock()
+	defer fs.table.lock.Unlock()
+
+	switch fs.query {
+	case "config":
+		if len(args) != 1 || args[0] != "tenant" {
+			return nil, errors.New("invalid args")
+		}
+		return &fakeRows{columns: fs.table.columns, rows: fs.table.rows}, nil
+	case "version":
+		return &fakeRows{columns: []string{"version"}, rows: [][]driver.Value{{fs.table.version}}}, nil
+	}
+	return nil, errors.New("invalid query")
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (fr *fakeRows) Columns() []string { return fr.columns }
+func (fr *fakeRows) Close() error      { return nil }
+
+func (fr *fakeRows) Next(dest []driver.Value) error {
+	if len(fr.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, fr.rows[0])
+	fr.rows = fr.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("onion-fake", fakeDriver{})
+}
+
+func openTable(name string, columns ...string) (*sql.DB, *fakeTable) {
+	t := &fakeTable{columns: columns}
+	tables.Lock()
+	tables.m[name] = t
+	tables.Unlock()
+
+	db, err := sql.Open("onion-fake", name)
+	So(err, ShouldBeNil)
+	return db, t
+}
+
+func waitFor(o *onion.Onion, key string, value int) bool {
+	for i := 0; i < 100; i++ {
+		if o.GetInt(key) == value {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestNewSQLLayer(t *testing.T) {
+	Convey("Load the rows with types", t, func() {
+		db, table := openTable("typed", "key", "value", "type")
+		table.set(1,
+			[]driver.Value{"db.host", "localhost", nil},
+			[]driver.Value{"db.port", "5432", "int"},
+			[]driver.Value{"db.ratio", "0.5", "float"},
+			[]driver.Value{"db.ssl", "true", "bool"},
+			[]driver.Value{"db.timeout", "2s", "duration"},
+			[]driver.Value{"hosts", `["a", "b"]`, "json"},
+			[]driver.Value{"removed", nil, "string"},
+		)
+
+		l, err := NewSQLLayer(db, Options{Query: "config", Args: []interface{}{"tenant"}})
+		So(err, ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetString("db.host"), ShouldEqual, "localhost")
+		So(o.GetInt("db.port"), ShouldEqual, 5432)
+		So(o.GetFloat64("db.ratio"), ShouldEqual, 0.5)
+		So(o.GetBool("db.ssl"), ShouldBeTrue)
+		So(o.GetDuration("db.timeout"), ShouldEqual, 2*time.Second)
+		So(o.GetStringSlice("hosts"), ShouldResemble, []string{"a", "b"})
+		_, ok := o.Get("removed")
+		So(ok, ShouldBeFalse)
+
+		Convey("invalid rows", func() {
+			for _, row := range [][]driver.Value{
+				{"db.port", "invalid", "int"},
+				{"db.port", "1", "unknown"},
+				{"db.host.name", "x", nil},
+				{"db", "x", nil},
+			} {
+				table.set(1, []driver.Value{"db.host", "localhost", nil}, []driver.Value{"db.port", "1", "int"}, row)
+				_, err := NewSQLLayer(db, Options{Query: "config", Args: []interface{}{"tenant"}})
+				So(err, ShouldNotBeNil)
+			}
+
+			table.set(1, []driver.Value{"db/host", "localhost", nil}, []driver.Value{"db/host/name", "x", nil})
+			_, err := NewSQLLayer(db, Options{Query: "config", Args: []interface{}{"tenant"}, Separator: "/"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `key "db/host" is not a map`)
+
+			_, err = NewSQLLayer(db, Options{Query: "invalid"})
+			So(err, ShouldNotBeNil)
+			_, err = NewSQLLayer(db, Options{Query: "version"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Refresh on the version change", t, func() {
+		db, table := openTable("versioned", "name", "value")
+		table.set(1, []driver.Value{"rate/limit", "10"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		l, err := NewSQLLayerContext(ctx, db, Options{
+			Query:        "config",
+			Args:         []interface{}{"tenant"},
+			Separator:    "/",
+			VersionQuery: "version",
+			Interval:     10 * time.Millisecond,
+		})
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		So(o.GetInt("rate.limit"), ShouldEqual, 10)
+
+		// Same version, no reload
+		table.set(1, []driver.Value{"rate/limit", "20"})
+		time.Sleep(50 * time.Millisecond)
+		So(o.GetInt("rate.limit"), ShouldEqual, 10)
+
+		table.set(2, []driver.Value{"rate/limit", "20"})
+		So(waitFor(o, "rate.limit", 20), ShouldBeTrue)
+	})
+
+	Convey("Reload without the version query", t, func() {
+		db, table := openTable("reload", "key", "value")
+		table.set(0, []driver.Value{"number", "1"})
+
+		l, err := NewSQLLayer(db, Options{Query: "config", Args: []interface{}{"tenant"}})
+		So(err, ShouldBeNil)
+		o := onion.New(l)
+		So(o.GetInt("number"), ShouldEqual, 1)
+
+		table.set(0, []driver.Value{"number", "2"})
+		watch := o.ReloadWatch()
+		res := o.Reload(context.Background())
+		So(res[0].Err, ShouldBeNil)
+		<-watch
+		So(o.GetInt("number"), ShouldEqual, 2)
+	})
+}
+
+func TestSQLLayerSpec(t *testing.T) {
+	Convey("SQL layer from the spec", t, func() {
+		_, table := openTable("spec", "key", "value")
+		table.set(0, []driver.Value{"db.port", "5432"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		spec := `{"layers": [{"type": "sql", "driver": "onion-fake", "dsn": "spec", "query": "config", "args": ["tenant"]}]}`
+		o, err := onion.FromSpecContext(ctx, strings.NewReader(spec), "json", nil)
+		So(err, ShouldBeNil)
+		So(o.GetInt("db.port"), ShouldEqual, 5432)
+
+		// The db is closed with the context
+		So(table.closedConns(), ShouldEqual, 0)
+		cancel()
+		for i := 0; i < 100 && table.closedConns() == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(table.closedConns(), ShouldEqual, 1)
+
+		spec = `{"layers": [{"type": "sql", "driver": "unknown"}]}`
+		_, err = onion.FromSpec(strings.NewReader(spec), "json", nil)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,402 @@
+// Package dotenvloader is used to handle .env files in Onion file layer.
+// for using this package, just import it
+//
+// 		import (
+// 			_ "github.com/goraz/onion/loaders/dotenv"
+// 		)
+//
+// Files with env or dotenv extension (including the `.env` file itself) are loaded as a flat
+// map, the keys are exactly the same as the file. for the nested keys like the
+// onion.NewEnvLayerPrefix use the NewDecoder or NewDotEnvLayer.
+//
+// The format supports `export` prefix, comments, single quoted (literal) and double quoted values
+// (with escapes), multi-line quoted values and `$VAR`, `${VAR}`, `${VAR:-default}` and
+// `${VAR-default}` expansion. the variables are looked up in the file first and then in the process
+// environment. the names in braces may contain '-' (like `${MY-VAR}`), for the `-` default the
+// longest defined name wins.
+package dotenvloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/goraz/onion"
+	"github.com/goraz/onion/internal/maputil"
+)
+
+type dotenvLoader struct {
+	separator string
+	prefix    string
+	nested    bool
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	vars map[string]string
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("dotenv: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipLineEnd skips the rest of the line, it should be empty or a comment
+func (p *parser) skipLineEnd() error {
+	p.skipSpaces()
+	if p.eof() {
+		return nil
+	}
+	switch p.src[p.pos] {
+	case '#':
+		for !p.eof() && p.src[p.pos] != '\n' {
+			p.pos++
+		}
+	case '\r', '\n':
+	default:
+		return p.errorf("unexpected character %q", p.src[p.pos])
+	}
+	return nil
+}
+
+func isKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *parser) readKey() string {
+	start := p.pos
+	for !p.eof() && isKeyChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) lookup(name string) (string, bool) {
+	if v, ok := p.vars[name]; ok {
+		return v, true
+	}
+	return os.LookupEnv(name)
+}
+
+// expand reads the variable at s[i] (which is $) and returns the value and the next index
+func (p *parser) expand(s string, i int) (string, int) {
+	if i+1 >= len(s) {
+		return "$", i + 1
+	}
+
+	if s[i+1] != '{' {
+		j := i + 1
+		for j < len(s) && isNameChar(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			return "$", i + 1
+		}
+		v, _ := p.lookup(s[i+1 : j])
+		return v, j
+	}
+
+	end := strings.IndexByte(s[i:], '}')
+	if end < 0 {
+		return s[i:], len(s)
+	}
+	expr := s[i+2 : i+end]
+	next := i + end + 1
+	name := expr
+	for j := 0; j < len(expr); j++ {
+		if !isKeyChar(expr[j]) {
+			name = expr[:j]
+			break
+		}
+	}
+	if strings.HasPrefix(expr[len(name):], ":-") {
+		if v, ok := p.lookup(name); ok && v != "" {
+			return v, next
+		}
+		return p.expandAll(expr[len(name)+2:]), next
+	}
+	if name == expr {
+		if v, ok := p.lookup(name); ok {
+			return v, next
+		}
+	}
+	// The names may contain '-' too, so the longest defined name before a '-' is the variable
+	for idx := strings.LastIndexByte(name, '-'); idx > 0; idx = strings.LastIndexByte(name[:idx], '-') {
+		if v, ok := p.lookup(name[:idx]); ok {
+			return v, next
+		}
+	}
+	if idx := strings.IndexByte(name, '-'); idx >= 0 {
+		return p.expandAll(expr[idx+1:]), next
+	}
+	v, _ := p.lookup(expr)
+	return v, next
+}
+
+func (p *parser) expandAll(s string) string {
+	buf := &strings.Builder{}
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			buf.WriteByte(s[i])
+			i++
+			continue
+		}
+		var v string
+		v, i = p.expand(s, i)
+		buf.WriteString(v)
+	}
+	return buf.String()
+}
+
+func (p *parser) readSingleQuoted() (string, error) {
+	p.pos++
+	end := strings.IndexByte(p.src[p.pos:], '\'')
+	if end < 0 {
+		return "", p.errorf("unterminated single quoted value")
+	}
+	v := p.src[p.pos : p.pos+end]
+	p.line += strings.Count(v, "\n")
+	p.pos += end + 1
+	return v, nil
+}
+
+func (p *parser) readDoubleQuoted() (string, error) {
+	p.pos++
+	start := p.pos
+	for ; !p.eof() && p.src[p.pos] != '"'; p.pos++ {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated double quoted value")
+	}
+	raw := p.src[start:p.pos]
+	p.line += strings.Count(raw, "\n")
+	p.pos++
+
+	buf := &strings.Builder{}
+	for i := 0; i < len(raw); {
+		switch c := raw[i]; c {
+		case '\\':
+			i++
+			if i >= len(raw) {
+				buf.WriteByte('\\')
+				continue
+			}
+			switch raw[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case '"', '\\', '$', '\'':
+				buf.WriteByte(raw[i])
+			default:
+				buf.WriteByte('\\')
+				buf.WriteByte(raw[i])
+			}
+			i++
+		case '$':
+			var v string
+			v, i = p.expand(raw, i)
+			buf.WriteString(v)
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String(), nil
+}
+
+func (p *parser) readUnquoted() string {
+	start := p.pos
+	for !p.eof() && p.src[p.pos] != '\n' {
+		// The # is a comment only after a space, so `a#b` is a valid value
+		if p.src[p.pos] == '#' && p.pos > start && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+			break
+		}
+		p.pos++
+	}
+	v := strings.TrimRight(p.src[start:p.pos], " \t\r")
+	for !p.eof() && p.src[p.pos] != '\n' {
+		p.pos++
+	}
+	return p.expandAll(v)
+}
+
+func (p *parser) readValue() (string, error) {
+	if p.eof() {
+		return "", nil
+	}
+
+	var (
+		v   string
+		err error
+	)
+	switch p.src[p.pos] {
+	case '\'':
+		v, err = p.readSingleQuoted()
+	case '"':
+		v, err = p.readDoubleQuoted()
+	default:
+		return p.readUnquoted(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return v, p.skipLineEnd()
+}
+
+func (p *parser) parse() error {
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			return nil
+		}
+
+		switch p.src[p.pos] {
+		case '\n':
+			p.line++
+			p.pos++
+			continue
+		case '\r':
+			p.pos++
+			continue
+		case '#':
+			if err := p.skipLineEnd(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := p.readKey()
+		if key == "export" && !p.eof() && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+			p.skipSpaces()
+			key = p.readKey()
+		}
+		if key == "" {
+			return p.errorf("invalid key")
+		}
+		p.skipSpaces()
+		if p.eof() || p.src[p.pos] != '=' {
+			return p.errorf("missing = after %q", key)
+		}
+		p.pos++
+		p.skipSpaces()
+
+		v, err := p.readValue()
+		if err != nil {
+			return err
+		}
+		p.vars[key] = v
+	}
+}
+
+// Parse reads a .env stream and returns the variables
+func Parse(r io.Reader) (map[string]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		src:  string(b),
+		line: 1,
+		vars: make(map[string]string),
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.vars, nil
+}
+
+func (dl *dotenvLoader) Decode(_ context.Context, r io.Reader) (map[string]interface{}, error) {
+	vars, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]interface{})
+	if !dl.nested {
+		for k, v := range vars {
+			ret[k] = v
+		}
+		return ret, nil
+	}
+
+	pf := ""
+	if dl.prefix != "" {
+		pf = strings.ToUpper(dl.prefix) + dl.separator
+	}
+	for k, v := range vars {
+		if !strings.HasPrefix(k, pf) {
+			continue
+		}
+		ck := strings.ToLower(strings.TrimPrefix(k, pf))
+		ret = maputil.Build(ret, v, strings.Split(ck, dl.separator)...)
+	}
+	return ret, nil
+}
+
+// NewDecoder returns a .env decoder that maps the keys into the nested structure exactly like the
+// onion.NewEnvLayerPrefix, so with "_" separator and "APP" prefix the APP_DB_HOST is the db.host
+// key, keys without the prefix are ignored. an empty prefix means all the keys.
+func NewDecoder(separator, prefix string) onion.Decoder {
+	return &dotenvLoader{
+		separator: separator,
+		prefix:    prefix,
+		nested:    true,
+	}
+}
+
+// NewDotEnvLayer loads a .env file with the nested keys, see NewDecoder. a non-nil cipher is used
+// to decrypt the file first
+func NewDotEnvLayer(path, separator, prefix string, c onion.Cipher) (onion.Layer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var r io.Reader = f
+	if c != nil {
+		b, err := c.Decrypt(f)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	data, err := NewDecoder(separator, prefix).Decode(context.Background(), r)
+	if err != nil {
+		return nil, err
+	}
+	return onion.NewMapLayer(data), nil
+}
+
+func init() {
+	onion.RegisterDecoder(&dotenvLoader{}, "env", "dotenv")
+}
@@ -0,0 +1,96 @@
+package dotenvloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/goraz/onion"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const sample = `# comment line
+export APP_DB_HOST=db.local
+APP_DB_PORT = 5432 # inline comment
+APP_NAME='single $NOT_EXPANDED'
+APP_GREETING="hello\n\"world\""
+APP_URL=postgres://${APP_DB_HOST}:$APP_DB_PORT/db
+APP_HASH=a#b
+APP_DEFAULT=${MISSING_DOTENV_VAR:-fallback}
+APP_FROM_ENV=${DOTENV_TEST_VAR}
+APP_MULTI="line1
+line2"
+EMPTY=
+OTHER_KEY=other
+`
+
+func TestDotEnvLoader(t *testing.T) {
+	Convey("Load a .env file", t, func() {
+		So(os.Setenv("DOTENV_TEST_VAR", "from-env"), ShouldBeNil)
+		defer func() { _ = os.Unsetenv("DOTENV_TEST_VAR") }()
+
+		Convey("flat keys with the registered decoder", func() {
+			l, err := NewStreamLayer(bytes.NewBufferString(sample), "env", nil)
+			So(err, ShouldBeNil)
+			o := New(l)
+			So(o.GetString("APP_DB_HOST"), ShouldEqual, "db.local")
+			So(o.GetInt("APP_DB_PORT"), ShouldEqual, 5432)
+			So(o.GetString("APP_NAME"), ShouldEqual, "single $NOT_EXPANDED")
+			So(o.GetString("APP_GREETING"), ShouldEqual, "hello\n\"world\"")
+			So(o.GetString("APP_URL"), ShouldEqual, "postgres://db.local:5432/db")
+			So(o.GetString("APP_HASH"), ShouldEqual, "a#b")
+			So(o.GetString("APP_DEFAULT"), ShouldEqual, "fallback")
+			So(o.GetString("APP_FROM_ENV"), ShouldEqual, "from-env")
+			So(o.GetString("APP_MULTI"), ShouldEqual, "line1\nline2")
+			v, ok := o.Get("EMPTY")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, "")
+		})
+
+		Convey("nested keys like the env layer", func() {
+			dir, err := ioutil.TempDir(os.TempDir(), "onion-dotenv-*")
+			So(err, ShouldBeNil)
+			defer func() { _ = os.RemoveAll(dir) }()
+			fl := filepath.Join(dir, ".env")
+			So(ioutil.WriteFile(fl, []byte(sample), 0600), ShouldBeNil)
+
+			l, err := NewDotEnvLayer(fl, "_", "app", nil)
+			So(err, ShouldBeNil)
+			o := New(l)
+			So(o.GetString("db.host"), ShouldEqual, "db.local")
+			So(o.GetInt("db.port"), ShouldEqual, 5432)
+			_, ok := o.Get("other.key")
+			So(ok, ShouldBeFalse)
+
+			l, err = NewFileLayer(fl, nil)
+			So(err, ShouldBeNil)
+			So(New(l).GetString("OTHER_KEY"), ShouldEqual, "other")
+		})
+
+		Convey("variable names with dash", func() {
+			vars, err := Parse(bytes.NewBufferString(`MY-VAR=dash
+A=plain
+DASH=${MY-VAR}
+DASH_DEFAULT=${MY-VAR:-x}
+PLAIN_DEFAULT=${A-other}
+MISSING_DEFAULT=${MISSING_DOTENV_VAR-some value}
+MISSING_DASH=${MISSING-DOTENV-VAR:-fallback}
+`))
+			So(err, ShouldBeNil)
+			So(vars["DASH"], ShouldEqual, "dash")
+			So(vars["DASH_DEFAULT"], ShouldEqual, "dash")
+			So(vars["PLAIN_DEFAULT"], ShouldEqual, "plain")
+			So(vars["MISSING_DEFAULT"], ShouldEqual, "some value")
+			So(vars["MISSING_DASH"], ShouldEqual, "fallback")
+		})
+
+		Convey("invalid files", func() {
+			for _, invalid := range []string{"=value", "KEY value", "KEY='open", "KEY=\"open", "KEY='a' b"} {
+				_, err := NewStreamLayer(bytes.NewBufferString(invalid), "env", nil)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
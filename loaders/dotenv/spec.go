@@ -0,0 +1,14 @@
+package dotenvloader
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(_ context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		opt := spec.Options
+		return NewDotEnvLayer(opt.GetString("path"), opt.GetStringDefault("separator", "_"), opt.GetString("prefix"), spec.Cipher)
+	}, "dotenv")
+}
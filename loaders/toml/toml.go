@@ -6,7 +6,7 @@
 // 		)
 //
 // There is no need to do anything else, if you load a file with toml
-// extension, the toml loader is doing his job.
+// extension, the toml loader is doing his job. the writable layers use it to write toml files too.
 package tomlloader
 
 import (
@@ -29,6 +29,17 @@ func (tl *tomlLoader) Decode(_ context.Context, r io.Reader) (map[string]interfa
 	return config.ToMap(), nil
 }
 
+func (tl *tomlLoader) Encode(_ context.Context, w io.Writer, data map[string]interface{}) error {
+	tree, err := toml.TreeFromMap(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = tree.WriteTo(w)
+	return err
+}
+
 func init() {
 	onion.RegisterDecoder(&tomlLoader{}, "toml")
+	onion.RegisterEncoder(&tomlLoader{}, "toml")
 }
@@ -2,6 +2,10 @@ package tomlloader
 
 import (
 	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/goraz/onion"
@@ -36,3 +40,25 @@ func TestTomlLoader1(t *testing.T) {
 		})
 	})
 }
+
+func TestTomlWrite(t *testing.T) {
+	Convey("Write back into a toml file", t, func() {
+		dir, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		path := filepath.Join(dir, "app.toml")
+		So(ioutil.WriteFile(path, []byte("[db]\nhost = \"localhost\"\nport = 5432\n"), 0644), ShouldBeNil)
+		l, err := NewFileLayer(path, nil)
+		So(err, ShouldBeNil)
+		So(l.(WritableLayer).Set("db.host", "db.local"), ShouldBeNil)
+		So(l.(WritableLayer).Delete("db.port"), ShouldBeNil)
+		So(l.(WritableLayer).Commit(context.Background()), ShouldBeNil)
+
+		l, err = NewFileLayer(path, nil)
+		So(err, ShouldBeNil)
+		o := New(l)
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetInt("db.port"), ShouldEqual, 0)
+	})
+}
@@ -6,7 +6,7 @@
 // 		)
 //
 // There is no need to do anything else, if you load a file with yaml/yml
-// extension, the yaml loader is doing his job.
+// extension, the yaml loader is doing his job. the writable layers use it to write yaml files too.
 package yamlloader
 
 import (
@@ -31,6 +31,16 @@ func (yl yamlLoader) Decode(_ context.Context, r io.Reader) (map[string]interfac
 	return ret, nil
 }
 
+func (yl yamlLoader) Encode(_ context.Context, w io.Writer, data map[string]interface{}) error {
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
 func init() {
 	onion.RegisterDecoder(&yamlLoader{}, "yml", "yaml")
+	onion.RegisterEncoder(&yamlLoader{}, "yml", "yaml")
 }
@@ -2,6 +2,10 @@ package yamlloader
 
 import (
 	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/goraz/onion"
@@ -41,3 +45,24 @@ ALALA`)
 		})
 	})
 }
+
+func TestYamlWrite(t *testing.T) {
+	Convey("Write back into a yaml file", t, func() {
+		dir, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		path := filepath.Join(dir, "app.yaml")
+		So(ioutil.WriteFile(path, []byte("db:\n  host: localhost\n  port: 5432\n"), 0644), ShouldBeNil)
+		l, err := NewFileLayer(path, nil)
+		So(err, ShouldBeNil)
+		So(l.(WritableLayer).Set("db.host", "db.local"), ShouldBeNil)
+		So(l.(WritableLayer).Commit(context.Background()), ShouldBeNil)
+
+		l, err = NewFileLayer(path, nil)
+		So(err, ShouldBeNil)
+		o := New(l)
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetInt("db.port"), ShouldEqual, 5432)
+	})
+}
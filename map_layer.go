@@ -1,19 +1,93 @@
 package onion
 
+import (
+	"context"
+	"sync"
+)
+
 // mapLayer is a layer based on maps. this layer can be used in other type of layers
 type mapLayer struct {
+	lock sync.RWMutex
 	data map[string]interface{}
+
+	changes ChangeSet
+	ctx     context.Context
+	notify  chan struct{}
+	c       chan map[string]interface{}
 }
 
 func (m *mapLayer) Load() map[string]interface{} {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
 	return m.data
 }
 
 func (m *mapLayer) Watch() <-chan map[string]interface{} {
-	return nil
+	return m.c
+}
+
+// Set stages a change in the map, see WritableLayer
+func (m *mapLayer) Set(key string, value interface{}) error {
+	return m.changes.Set(key, value)
+}
+
+// Delete stages a delete in the map, see WritableLayer
+func (m *mapLayer) Delete(key string) error {
+	return m.changes.Delete(key)
+}
+
+// Commit applies the changes on the map, the map passed to the NewMapLayer is not modified
+func (m *mapLayer) Commit(ctx context.Context) error {
+	return m.changes.Commit(m.Load(), func(data map[string]interface{}) error {
+		m.lock.Lock()
+		m.data = data
+		m.lock.Unlock()
+
+		if m.notify != nil {
+			select {
+			case m.notify <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	})
+}
+
+// send sends the latest data on each commit, the channel is closed when the context is done
+func (m *mapLayer) send() {
+	defer close(m.c)
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-m.notify:
+			select {
+			case m.c <- m.Load():
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// NewMapLayerContext returns a map layer like the NewMapLayer, the committed changes are sent to
+// the watch channel until the context is done
+func NewMapLayerContext(ctx context.Context, data ...map[string]interface{}) Layer {
+	ret := &mapLayer{
+		data:   mergeLayersData(data...),
+		ctx:    ctx,
+		notify: make(chan struct{}, 1),
+		c:      make(chan map[string]interface{}),
+	}
+
+	go ret.send()
+	return ret
 }
 
-// NewMapLayer returns a basic map layer, this layer is simply holds a map of values
+// NewMapLayer returns a basic map layer, this layer is simply holds a map of values. it implements
+// the WritableLayer, the changes are kept in memory. the layer is not watched, so the committed
+// changes are only seen by the configs created after the commit, see NewMapLayerContext
 func NewMapLayer(data ...map[string]interface{}) Layer {
 	ret := &mapLayer{
 		data: mergeLayersData(data...),
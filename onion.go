@@ -20,6 +20,14 @@ type Layer interface {
 	Watch() <-chan map[string]interface{}
 }
 
+// SecretLayer is an optional interface for the layers that know some of their keys are secrets,
+// each key is the path of the key, for example []string{"db", "password"}
+type SecretLayer interface {
+	Layer
+	// SecretKeys returns the secret keys of the layer
+	SecretKeys() [][]string
+}
+
 var o = &Onion{}
 
 // Onion is a layer base configuration system
@@ -33,6 +41,11 @@ type Onion struct {
 	data map[Layer]map[string]interface{}
 
 	reload chan struct{}
+
+	// The async loading, see AddLayersAsyncContext
+	pending  int
+	ready    chan struct{}
+	loadErrs []error
 }
 
 func (o *Onion) watchLayer(ctx context.Context, l Layer) {
@@ -145,6 +158,31 @@ func (o *Onion) ReloadWatch() <-chan struct{} {
 	return o.reload
 }
 
+// SecretKeys returns all the secret keys in the global config, see SecretLayer
+func SecretKeys() []string {
+	return o.SecretKeys()
+}
+
+// SecretKeys returns all the secret keys reported by the layers, using the current delimiter
+func (o *Onion) SecretKeys() []string {
+	// GetDelimiter may set the default delimiter, so it is not called under the read lock
+	delimiter := o.GetDelimiter()
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	var res []string
+	for _, l := range o.ll {
+		sl, ok := l.(SecretLayer)
+		if !ok {
+			continue
+		}
+		for _, k := range sl.SecretKeys() {
+			res = append(res, strings.Join(k, delimiter))
+		}
+	}
+	return res
+}
+
 // Get try to get the key from config layers
 func Get(key string) (interface{}, bool) {
 	return o.Get(key)
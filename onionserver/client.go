@@ -0,0 +1,155 @@
+package onionserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goraz/onion"
+)
+
+const (
+	pollWait   = 30 * time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// ErrNotMap is returned when the client address points to a value which is not a map, like a
+// scalar or a list, it can not be a layer
+var ErrNotMap = errors.New("the value is not a map")
+
+// minPollInterval is the minimum time between two polls, if the server does not support the wait
+// and returns immediately
+var minPollInterval = time.Second
+
+type clientLayer struct {
+	data     map[string]interface{}
+	c        chan map[string]interface{}
+	interval time.Duration
+}
+
+func (cl *clientLayer) Load() map[string]interface{} {
+	return cl.data
+}
+
+func (cl *clientLayer) Watch() <-chan map[string]interface{} {
+	return cl.c
+}
+
+func fetch(ctx context.Context, client *http.Client, u string, etag string) (map[string]interface{}, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("unexpected status %q from %s", resp.Status, u)
+	}
+
+	var v interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, "", err
+	}
+	data, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotMap, u)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func (cl *clientLayer) poll(ctx context.Context, client *http.Client, u string, etag string) {
+	backoff := time.Second
+	var last time.Time
+	for {
+		if d := cl.interval - time.Since(last); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return
+			}
+		}
+		last = time.Now()
+
+		data, newTag, err := fetch(ctx, client, u, etag)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("error:", err) // Better log support
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
+		backoff = time.Second
+		if data == nil {
+			continue
+		}
+		etag = newTag
+		select {
+		case cl.c <- data:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// NewClientLayerContext creates a layer that reads the config from an onion server. the address
+// can point to the root or any sub tree (a map) of the server, the other values are ErrNotMap. the layer uses long poll to watch for the
+// changes until the context is done. nil client means the http.DefaultClient
+func NewClientLayerContext(ctx context.Context, address string, client *http.Client) (onion.Layer, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	data, etag, err := fetch(ctx, client, address, "")
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("wait", pollWait.String())
+	u.RawQuery = q.Encode()
+
+	cl := &clientLayer{
+		data:     data,
+		c:        make(chan map[string]interface{}),
+		interval: minPollInterval,
+	}
+	go cl.poll(ctx, client, u.String(), etag)
+
+	return cl, nil
+}
+
+// NewClientLayer creates a new onion server client layer, see NewClientLayerContext
+func NewClientLayer(address string, client *http.Client) (onion.Layer, error) {
+	return NewClientLayerContext(context.Background(), address, client)
+}
@@ -0,0 +1,95 @@
+package onionserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/goraz/onion"
+)
+
+func TestClientLayer(t *testing.T) {
+	Convey("Client layer reads and watches the server", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		l, err := onion.NewStreamLayerContext(ctx, mapToJson(map[string]interface{}{
+			"app": map[string]interface{}{"port": 8080},
+		}), "json", nil)
+		So(err, ShouldBeNil)
+		srv := httptest.NewServer(NewHandler(onion.NewContext(ctx, l)))
+		defer func() {
+			// Cancel the long poll before closing the server
+			cancel()
+			srv.Close()
+		}()
+
+		cl, err := NewClientLayerContext(ctx, srv.URL+"/app", nil)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, cl)
+		So(o.GetInt("port"), ShouldEqual, 8080)
+
+		watch := o.ReloadWatch()
+		So(l.(streamReload).Reload(ctx, mapToJson(map[string]interface{}{
+			"app": map[string]interface{}{"port": 9090},
+		}), "json"), ShouldBeNil)
+		<-watch
+		So(o.GetInt("port"), ShouldEqual, 9090)
+
+		_, err = NewClientLayerContext(ctx, srv.URL+"/missing", nil)
+		So(err, ShouldNotBeNil)
+
+		// A scalar is not a layer
+		_, err = NewClientLayerContext(ctx, srv.URL+"/app/port", nil)
+		So(errors.Is(err, ErrNotMap), ShouldBeTrue)
+	})
+}
+
+func TestClientPollInterval(t *testing.T) {
+	Convey("The client does not spin if the server ignores the wait", t, func() {
+		old := minPollInterval
+		minPollInterval = 100 * time.Millisecond
+		defer func() { minPollInterval = old }()
+
+		var count int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&count, 1)
+			_, _ = w.Write([]byte(`{"port": 8080}`))
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer func() {
+			cancel()
+			srv.Close()
+		}()
+
+		cl, err := NewClientLayerContext(ctx, srv.URL, nil)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, cl)
+		So(o.GetInt("port"), ShouldEqual, 8080)
+		time.Sleep(350 * time.Millisecond)
+		So(atomic.LoadInt32(&count), ShouldBeBetweenOrEqual, 2, 6)
+	})
+}
+
+func TestClientLayerSpec(t *testing.T) {
+	Convey("Client layer from the spec", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		srv := httptest.NewServer(NewHandler(onion.New(onion.NewMapLayer(map[string]interface{}{"port": 8080}))))
+		defer func() {
+			cancel()
+			srv.Close()
+		}()
+
+		spec := `{"layers": [{"type": "http", "address": "` + srv.URL + `"}]}`
+		o, err := onion.FromSpecContext(ctx, strings.NewReader(spec), "json", nil)
+		So(err, ShouldBeNil)
+		So(o.GetInt("port"), ShouldEqual, 8080)
+	})
+}
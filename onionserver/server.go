@@ -0,0 +1,327 @@
+// Package onionserver serves an onion instance over http. one service can aggregate all the layers
+// (files, etcd, env, ...) and many lightweight clients can read the merged config using the client
+// layer in this package.
+//
+// The request path is the key in the config, for example `/db/host` is the `db.host` key, and the
+// root path is the whole config. the list items are addressed by their index, like `/hosts/0`. the response is json by default, yaml is used if the `format`
+// query parameter is `yaml` or the Accept header asks for it.
+// Every response has an ETag and the conditional GET is supported, also with the `wait` query
+// parameter (like `?wait=30s`) the request is blocked until the config changes or the wait is over.
+package onionserver
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/goraz/onion"
+)
+
+const (
+	defaultMaxWait = time.Minute
+	// Redacted is the value used instead of the secret values
+	Redacted = "********"
+)
+
+// AccessFunc is called to check if the request can read the key, the key is the requested path in
+// the onion delimiter format (empty string for the root)
+type AccessFunc func(r *http.Request, key string) bool
+
+// Handler is an http.Handler to serve the merged config of an onion
+type Handler struct {
+	// Access is called for each request, nil means all keys are accessible
+	Access AccessFunc
+	// SecretKeys is a list of keys (or path.Match patterns) to redact in the response. each pattern
+	// is matched against the full key and also the last part of the key, so "password" redacts all
+	// keys named password and "db.password" only the one in db. the secret keys reported by the
+	// layers (see onion.SecretLayer) are always redacted
+	SecretKeys []string
+	// MaxWait is the maximum time a long poll request is blocked, default is one minute
+	MaxWait time.Duration
+
+	o *onion.Onion
+}
+
+// NewHandler returns a handler that serves the merged config of the onion
+func NewHandler(o *onion.Onion) *Handler {
+	return &Handler{
+		o: o,
+	}
+}
+
+// TokenAccess is an AccessFunc based on the bearer token in the Authorization header. each token
+// can access the list of keys (and their sub keys), an empty key means the whole config
+func TokenAccess(tokens map[string][]string, delimiter string) AccessFunc {
+	return func(r *http.Request, key string) bool {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return false
+		}
+		allowed, ok := tokens[strings.TrimPrefix(auth, "Bearer ")]
+		if !ok {
+			return false
+		}
+		for _, prefix := range allowed {
+			if prefix == "" || key == prefix || strings.HasPrefix(key, prefix+delimiter) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (h *Handler) maxWait() time.Duration {
+	if h.MaxWait <= 0 {
+		return defaultMaxWait
+	}
+	return h.MaxWait
+}
+
+func (h *Handler) isSecret(key, name string, layerSecrets map[string]bool) bool {
+	if layerSecrets[key] {
+		return true
+	}
+	for _, pattern := range h.SecretKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) redact(key, name string, v interface{}, layerSecrets map[string]bool) interface{} {
+	if h.isSecret(key, name, layerSecrets) {
+		return Redacted
+	}
+
+	delimiter := h.o.GetDelimiter()
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + delimiter + k
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = h.redact(join(k), k, t[k], layerSecrets)
+		}
+	case []interface{}:
+		// The items are checked with their index, and the maps in the list with their keys
+		for i := range t {
+			idx := strconv.Itoa(i)
+			t[i] = h.redact(join(idx), idx, t[i], layerSecrets)
+		}
+	}
+	return v
+}
+
+func (h *Handler) lookup(key string) (interface{}, bool) {
+	var v interface{} = mergedData(h.o)
+	if key == "" {
+		return v, true
+	}
+
+	for _, p := range strings.Split(key, h.o.GetDelimiter()) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[p]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func (h *Handler) render(key, format string) ([]byte, bool, error) {
+	v, ok := h.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	layerSecrets := make(map[string]bool)
+	for _, k := range h.o.SecretKeys() {
+		layerSecrets[k] = true
+	}
+	parts := strings.Split(key, h.o.GetDelimiter())
+	v = h.redact(key, parts[len(parts)-1], v, layerSecrets)
+
+	if format == "yaml" {
+		b, err := yaml.Marshal(v)
+		return b, true, err
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	return buf.Bytes(), true, err
+}
+
+func requestKey(r *http.Request, delimiter string) string {
+	p := strings.Trim(r.URL.Path, "/")
+	return strings.ReplaceAll(p, "/", delimiter)
+}
+
+func requestFormat(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "yaml", "yml":
+		return "yaml"
+	case "json":
+		return "json"
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "yaml") && !strings.Contains(accept, "json") {
+		return "yaml"
+	}
+	return "json"
+}
+
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP serves the requested key
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := requestKey(r, h.o.GetDelimiter())
+	if h.Access != nil && !h.Access(r, key) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var wait time.Duration
+	if ws := r.URL.Query().Get("wait"); ws != "" {
+		var err error
+		if wait, err = time.ParseDuration(ws); err != nil {
+			http.Error(w, fmt.Sprintf("invalid wait %q", ws), http.StatusBadRequest)
+			return
+		}
+		if wait > h.maxWait() {
+			wait = h.maxWait()
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	format := requestFormat(r)
+	for {
+		// Get the channel before reading the data, so there is no missed change
+		reload := h.o.ReloadWatch()
+		body, ok, err := h.render(key, format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		sum := sha1.Sum(body)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("Content-Type", "application/"+format)
+			w.WriteHeader(http.StatusOK)
+			if r.Method != http.MethodHead {
+				_, _ = w.Write(body)
+			}
+			return
+		}
+
+		if wait <= 0 {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		select {
+		case <-reload:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k := range t {
+			res[k] = normalize(t[k])
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k := range t {
+			res[fmt.Sprint(k)] = normalize(t[k])
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i := range t {
+			res[i] = normalize(t[i])
+		}
+		return res
+	}
+	return v
+}
+
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dm, dok := dst[k].(map[string]interface{})
+		sm, sok := v.(map[string]interface{})
+		if dok && sok {
+			merge(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// mergedData returns a copy of all the layers merged, without touching the layers data
+func mergedData(o *onion.Onion) map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, data := range o.LayersData() {
+		m, _ := normalize(data).(map[string]interface{})
+		merge(res, m)
+	}
+	return res
+}
@@ -0,0 +1,186 @@
+package onionserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v2"
+
+	"github.com/goraz/onion"
+)
+
+type streamReload interface {
+	onion.Layer
+	Reload(context.Context, io.Reader, string) error
+}
+
+func mapToJson(m map[string]interface{}) io.Reader {
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewReader(b)
+}
+
+func get(h http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	Convey("Serve the onion over http", t, func() {
+		base := onion.NewMapLayer(map[string]interface{}{
+			"db": map[string]interface{}{
+				"host":     "localhost",
+				"port":     5432,
+				"password": "base-secret",
+			},
+			"name": "base",
+		})
+		yml := map[string]interface{}{
+			"db": map[interface{}]interface{}{
+				"host": "db.local",
+			},
+		}
+		o := onion.New(base, onion.NewMapLayer(yml))
+		h := NewHandler(o)
+		h.SecretKeys = []string{"password"}
+
+		Convey("the root is the merged config", func() {
+			rec := get(h, "/", nil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			var data map[string]interface{}
+			So(json.Unmarshal(rec.Body.Bytes(), &data), ShouldBeNil)
+			So(data["name"], ShouldEqual, "base")
+			db := data["db"].(map[string]interface{})
+			So(db["host"], ShouldEqual, "db.local")
+			So(db["port"], ShouldEqual, 5432)
+			So(db["password"], ShouldEqual, Redacted)
+		})
+
+		Convey("sub tree and yaml", func() {
+			rec := get(h, "/db?format=yaml", nil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var data map[string]interface{}
+			So(yaml.Unmarshal(rec.Body.Bytes(), &data), ShouldBeNil)
+			So(data["host"], ShouldEqual, "db.local")
+			So(data["password"], ShouldEqual, Redacted)
+
+			rec = get(h, "/db/password", map[string]string{"Accept": "application/yaml"})
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, Redacted)
+
+			So(get(h, "/db/nothing", nil).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("access control", func() {
+			h.Access = TokenAccess(map[string][]string{"t1": {"db"}}, ".")
+			So(get(h, "/", nil).Code, ShouldEqual, http.StatusForbidden)
+			So(get(h, "/", map[string]string{"Authorization": "Bearer t1"}).Code, ShouldEqual, http.StatusForbidden)
+			So(get(h, "/db/host", map[string]string{"Authorization": "Bearer t1"}).Code, ShouldEqual, http.StatusOK)
+			So(get(h, "/dbx", map[string]string{"Authorization": "Bearer t1"}).Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("conditional get", func() {
+			rec := get(h, "/db", nil)
+			etag := rec.Header().Get("ETag")
+			So(etag, ShouldNotBeEmpty)
+			rec = get(h, "/db", map[string]string{"If-None-Match": etag})
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+			So(get(h, "/db", map[string]string{"If-None-Match": `"other"`}).Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestLongPoll(t *testing.T) {
+	Convey("Long poll waits for the change", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		l, err := onion.NewStreamLayerContext(ctx, mapToJson(map[string]interface{}{"key": "v1"}), "json", nil)
+		So(err, ShouldBeNil)
+		o := onion.NewContext(ctx, l)
+		h := NewHandler(o)
+		h.MaxWait = 100 * time.Millisecond
+
+		etag := get(h, "/", nil).Header().Get("ETag")
+		rec := get(h, "/?wait=1h", map[string]string{"If-None-Match": etag})
+		So(rec.Code, ShouldEqual, http.StatusNotModified)
+
+		So(get(h, "/?wait=nope", nil).Code, ShouldEqual, http.StatusBadRequest)
+
+		h.MaxWait = time.Minute
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = l.(streamReload).Reload(ctx, mapToJson(map[string]interface{}{"key": "v2"}), "json")
+		}()
+		rec = get(h, "/?wait=10s", map[string]string{"If-None-Match": etag})
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldContainSubstring, "v2")
+	})
+}
+
+type secretLayer struct {
+	onion.Layer
+}
+
+func (secretLayer) SecretKeys() [][]string {
+	return [][]string{{"api", "token"}}
+}
+
+func TestLayerSecrets(t *testing.T) {
+	Convey("Secret keys reported by the layers are redacted", t, func() {
+		l := secretLayer{onion.NewMapLayer(map[string]interface{}{
+			"api": map[string]interface{}{"token": "t0k3n", "url": "http://api"},
+		})}
+		h := NewHandler(onion.New(l))
+		rec := get(h, "/api", nil)
+		So(rec.Body.String(), ShouldNotContainSubstring, "t0k3n")
+		So(rec.Body.String(), ShouldContainSubstring, "http://api")
+	})
+}
+
+func TestRedactLists(t *testing.T) {
+	Convey("The secrets in the lists are redacted", t, func() {
+		o := onion.New(onion.NewMapLayer(map[string]interface{}{
+			"users": []interface{}{
+				map[string]interface{}{"name": "alice", "password": "s3cr3t"},
+				map[interface{}]interface{}{"name": "bob", "password": "hunt3r"},
+			},
+		}))
+		h := NewHandler(o)
+		h.SecretKeys = []string{"password"}
+		rec := get(h, "/users", nil)
+		So(rec.Body.String(), ShouldNotContainSubstring, "s3cr3t")
+		So(rec.Body.String(), ShouldNotContainSubstring, "hunt3r")
+		So(rec.Body.String(), ShouldContainSubstring, "alice")
+
+		// The layer data is not changed
+		v, _ := o.Get("users")
+		So(v.([]interface{})[0].(map[string]interface{})["password"], ShouldEqual, "s3cr3t")
+
+		// The list items by index
+		rec = get(h, "/users/1/name", nil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldEqual, "\"bob\"\n")
+		rec = get(h, "/users/0/password", nil)
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldContainSubstring, Redacted)
+		for _, target := range []string{"/users/2", "/users/-1", "/users/x", "/users/0/name/x"} {
+			So(get(h, target, nil).Code, ShouldEqual, http.StatusNotFound)
+		}
+	})
+}
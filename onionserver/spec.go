@@ -0,0 +1,13 @@
+package onionserver
+
+import (
+	"context"
+
+	"github.com/goraz/onion"
+)
+
+func init() {
+	onion.RegisterLayerFactory(func(ctx context.Context, spec onion.LayerSpec) (onion.Layer, error) {
+		return NewClientLayerContext(ctx, spec.Options.GetString("address"), nil)
+	}, "http")
+}
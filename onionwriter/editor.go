@@ -0,0 +1,82 @@
+package onionwriter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/goraz/onion"
+)
+
+// Editor changes the keys of a yaml or toml document in place, the comments, the order and the
+// formatting of the rest of the document are kept. the keys are separated by dot
+type Editor interface {
+	// Set sets the value of the key, the missing parents are created
+	Set(key string, value interface{}) error
+	// Delete removes the key, a missing key is ignored
+	Delete(key string) error
+	// Bytes returns the current document
+	Bytes() []byte
+}
+
+// NewEditor creates an editor for the document, the format is yaml (or yml) or toml
+func NewEditor(r io.Reader, format string) (Editor, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return newYAMLEditor(string(b))
+	case "toml":
+		return newTOMLEditor(string(b))
+	}
+	return nil, fmt.Errorf("format %q is not supported by the editor", format)
+}
+
+// EditFile opens the file in an editor based on the extension and writes it back if the edit
+// function is successful. the file is replaced atomically
+func EditFile(path string, edit func(Editor) error) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	e, err := NewEditor(bytes.NewReader(b), strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return err
+	}
+	if err := edit(e); err != nil {
+		return err
+	}
+
+	return onion.WriteFile(path, func(w io.Writer) error {
+		_, err := w.Write(e.Bytes())
+		return err
+	})
+}
+
+// splitLines splits the document into lines, the line ending of the document is returned too
+func splitLines(s string) ([]string, string) {
+	eol := "\n"
+	if strings.Contains(s, "\r\n") {
+		eol = "\r\n"
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), eol
+}
+
+// isComment returns true for the comment lines
+func isComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
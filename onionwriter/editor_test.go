@@ -0,0 +1,49 @@
+package onionwriter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEditFile(t *testing.T) {
+	Convey("Edit a file", t, func() {
+		dir, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		path := filepath.Join(dir, "app.yml")
+		So(ioutil.WriteFile(path, []byte("# The port\nport: 80\n"), 0600), ShouldBeNil)
+		So(EditFile(path, func(e Editor) error {
+			return e.Set("port", 8080)
+		}), ShouldBeNil)
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "# The port\nport: 8080\n")
+		fi, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+		failed := errors.New("failed")
+		So(EditFile(path, func(e Editor) error {
+			_ = e.Set("port", 1)
+			return failed
+		}), ShouldEqual, failed)
+		b, err = ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "# The port\nport: 8080\n")
+
+		files, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 1)
+
+		So(EditFile(filepath.Join(dir, "missing.toml"), func(Editor) error { return nil }), ShouldNotBeNil)
+		_, err = NewEditor(strings.NewReader("{}"), "json")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,619 @@
+package onionwriter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goraz/onion"
+	"github.com/pelletier/go-toml"
+)
+
+var (
+	bareKey  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	tomlDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// tomlEntry is a table header or a key/value in the document, the offsets are in the text. the
+// path of a key/value is the full path, with the table
+type tomlEntry struct {
+	path  []string
+	table bool
+	// array is true for the array of tables and the keys in them
+	array bool
+
+	lineStart, lineEnd int
+	valStart, valEnd   int
+}
+
+// tomlEditor is a line based toml editor, it finds the keys with a small scanner and changes only
+// the value of the key, so the comments and the formatting are kept
+type tomlEditor struct {
+	text string
+	eol  string
+}
+
+func newTOMLEditor(s string) (*tomlEditor, error) {
+	te := &tomlEditor{text: s, eol: "\n"}
+	if strings.Contains(s, "\r\n") {
+		te.eol = "\r\n"
+	}
+	if _, err := toml.Load(s); err != nil {
+		return nil, err
+	}
+	if _, err := parseTOML(s); err != nil {
+		return nil, err
+	}
+	return te, nil
+}
+
+func (te *tomlEditor) Bytes() []byte {
+	return []byte(te.text)
+}
+
+// update replaces the document if the result is a valid toml
+func (te *tomlEditor) update(s string) error {
+	if _, err := toml.Load(s); err != nil {
+		return fmt.Errorf("the change makes an invalid toml: %w", err)
+	}
+	te.text = s
+	return nil
+}
+
+type tomlScanner struct {
+	s   string
+	pos int
+}
+
+func (ts *tomlScanner) errorf(format string, args ...interface{}) error {
+	line := strings.Count(ts.s[:ts.pos], "\n") + 1
+	return fmt.Errorf("toml line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (ts *tomlScanner) peek(prefix string) bool {
+	return strings.HasPrefix(ts.s[ts.pos:], prefix)
+}
+
+// space skips the spaces, and the new lines and the comments if multiline is true
+func (ts *tomlScanner) space(multiline bool) {
+	for ts.pos < len(ts.s) {
+		switch ts.s[ts.pos] {
+		case ' ', '\t':
+		case '\r', '\n':
+			if !multiline {
+				return
+			}
+		case '#':
+			if !multiline {
+				return
+			}
+			for ts.pos < len(ts.s) && ts.s[ts.pos] != '\n' {
+				ts.pos++
+			}
+			continue
+		default:
+			return
+		}
+		ts.pos++
+	}
+}
+
+// lineEnd skips the comment at the end of the line and the new line
+func (ts *tomlScanner) lineEnd() error {
+	ts.space(false)
+	if ts.peek("#") {
+		for ts.pos < len(ts.s) && ts.s[ts.pos] != '\n' {
+			ts.pos++
+		}
+	}
+	if ts.peek("\r\n") {
+		ts.pos++
+	}
+	switch {
+	case ts.pos == len(ts.s):
+		return nil
+	case ts.s[ts.pos] == '\n':
+		ts.pos++
+		return nil
+	}
+	return ts.errorf("unexpected %q", ts.s[ts.pos])
+}
+
+// str skips a string and returns its value
+func (ts *tomlScanner) str() (string, error) {
+	start := ts.pos
+	q := ts.s[ts.pos]
+	multi := ts.peek(strings.Repeat(string(q), 3))
+	if multi {
+		ts.pos += 3
+	} else {
+		ts.pos++
+	}
+	for ts.pos < len(ts.s) {
+		c := ts.s[ts.pos]
+		switch {
+		case c == '\\' && q == '"':
+			ts.pos += 2
+			continue
+		case c == '\n' && !multi:
+			return "", ts.errorf("unterminated string")
+		case c == q && !multi:
+			ts.pos++
+			if q == '\'' {
+				return ts.s[start+1 : ts.pos-1], nil
+			}
+			return strconv.Unquote(ts.s[start:ts.pos])
+		case c == q && ts.peek(strings.Repeat(string(q), 3)):
+			ts.pos += 3
+			// Up to two quotes are allowed just before the closing quotes
+			for i := 0; i < 2 && ts.pos < len(ts.s) && ts.s[ts.pos] == q; i++ {
+				ts.pos++
+			}
+			return ts.s[start:ts.pos], nil
+		}
+		ts.pos++
+	}
+	return "", ts.errorf("unterminated string")
+}
+
+// key scans a dotted key
+func (ts *tomlScanner) key() ([]string, error) {
+	var path []string
+	for {
+		ts.space(false)
+		if ts.pos >= len(ts.s) {
+			return nil, ts.errorf("missing key")
+		}
+		switch ts.s[ts.pos] {
+		case '"', '\'':
+			if ts.peek(`"""`) || ts.peek(`'''`) {
+				return nil, ts.errorf("invalid key")
+			}
+			k, err := ts.str()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, k)
+		default:
+			start := ts.pos
+			for ts.pos < len(ts.s) && bareKey.MatchString(ts.s[ts.pos:ts.pos+1]) {
+				ts.pos++
+			}
+			if start == ts.pos {
+				return nil, ts.errorf("invalid key")
+			}
+			path = append(path, ts.s[start:ts.pos])
+		}
+		ts.space(false)
+		if !ts.peek(".") {
+			return path, nil
+		}
+		ts.pos++
+	}
+}
+
+// value skips a value
+func (ts *tomlScanner) value() error {
+	if ts.pos >= len(ts.s) {
+		return ts.errorf("missing value")
+	}
+	switch ts.s[ts.pos] {
+	case '"', '\'':
+		_, err := ts.str()
+		return err
+	case '[':
+		ts.pos++
+		for {
+			ts.space(true)
+			if ts.peek("]") {
+				ts.pos++
+				return nil
+			}
+			if err := ts.value(); err != nil {
+				return err
+			}
+			ts.space(true)
+			if ts.peek(",") {
+				ts.pos++
+			} else if !ts.peek("]") {
+				return ts.errorf("invalid array")
+			}
+		}
+	case '{':
+		ts.pos++
+		for {
+			ts.space(false)
+			if ts.peek("}") {
+				ts.pos++
+				return nil
+			}
+			if _, err := ts.key(); err != nil {
+				return err
+			}
+			if !ts.peek("=") {
+				return ts.errorf("missing =")
+			}
+			ts.pos++
+			ts.space(false)
+			if err := ts.value(); err != nil {
+				return err
+			}
+			ts.space(false)
+			if ts.peek(",") {
+				ts.pos++
+			} else if !ts.peek("}") {
+				return ts.errorf("invalid inline table")
+			}
+		}
+	}
+
+	start := ts.pos
+	for ts.pos < len(ts.s) && !strings.ContainsRune(" \t\r\n#,]}", rune(ts.s[ts.pos])) {
+		ts.pos++
+	}
+	// The date and time separated by space
+	if tomlDate.MatchString(ts.s[start:ts.pos]) && ts.pos+1 < len(ts.s) && ts.s[ts.pos] == ' ' && ts.s[ts.pos+1] >= '0' && ts.s[ts.pos+1] <= '9' {
+		ts.pos++
+		for ts.pos < len(ts.s) && !strings.ContainsRune(" \t\r\n#,]}", rune(ts.s[ts.pos])) {
+			ts.pos++
+		}
+	}
+	if start == ts.pos {
+		return ts.errorf("missing value")
+	}
+	return nil
+}
+
+// parseTOML returns the tables and the keys in the document
+func parseTOML(s string) ([]tomlEntry, error) {
+	ts := &tomlScanner{s: s}
+	var (
+		entries []tomlEntry
+		table   []string
+		array   bool
+	)
+	for ts.pos < len(s) {
+		lineStart := ts.pos
+		ts.space(false)
+		switch {
+		case ts.pos == len(s) || ts.peek("\n") || ts.peek("\r\n") || ts.peek("#"):
+			if err := ts.lineEnd(); err != nil {
+				return nil, err
+			}
+		case ts.peek("["):
+			array = ts.peek("[[")
+			ts.pos++
+			if array {
+				ts.pos++
+			}
+			path, err := ts.key()
+			if err != nil {
+				return nil, err
+			}
+			if !ts.peek("]") || (array && !ts.peek("]]")) {
+				return nil, ts.errorf("invalid table")
+			}
+			ts.pos++
+			if array {
+				ts.pos++
+			}
+			if err := ts.lineEnd(); err != nil {
+				return nil, err
+			}
+			table = path
+			entries = append(entries, tomlEntry{path: path, table: true, array: array, lineStart: lineStart, lineEnd: ts.pos})
+		default:
+			path, err := ts.key()
+			if err != nil {
+				return nil, err
+			}
+			if !ts.peek("=") {
+				return nil, ts.errorf("missing =")
+			}
+			ts.pos++
+			ts.space(false)
+			e := tomlEntry{path: append(append([]string{}, table...), path...), array: array, lineStart: lineStart, valStart: ts.pos}
+			if err := ts.value(); err != nil {
+				return nil, err
+			}
+			e.valEnd = ts.pos
+			if err := ts.lineEnd(); err != nil {
+				return nil, err
+			}
+			e.lineEnd = ts.pos
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlKey(path []string) string {
+	parts := make([]string, len(path))
+	for i := range path {
+		parts[i] = path[i]
+		if !bareKey.MatchString(path[i]) {
+			parts[i] = tomlQuote(path[i])
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func tomlQuote(s string) string {
+	b := &strings.Builder{}
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// tomlValue formats the value, the literal strings are kept if the old value is a literal string
+func tomlValue(v interface{}, old string) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", fmt.Errorf("toml does not support nil values")
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return tomlQuote(t.String()), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return "", fmt.Errorf("toml does not support nil values")
+		}
+		return tomlValue(rv.Elem().Interface(), old)
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		switch {
+		case math.IsNaN(f):
+			return "nan", nil
+		case math.IsInf(f, 1):
+			return "inf", nil
+		case math.IsInf(f, -1):
+			return "-inf", nil
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case reflect.String:
+		s := rv.String()
+		if strings.HasPrefix(old, "'") && !strings.HasPrefix(old, "'''") && !strings.ContainsAny(s, "'\r\n") {
+			return "'" + s + "'", nil
+		}
+		return tomlQuote(s), nil
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := range items {
+			item, err := tomlValue(rv.Index(i).Interface(), "")
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return "{}", nil
+		}
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			ks := fmt.Sprint(k.Interface())
+			keys = append(keys, ks)
+			values[ks] = rv.MapIndex(k).Interface()
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			item, err := tomlValue(values[k], "")
+			if err != nil {
+				return "", err
+			}
+			items[i] = tomlKey([]string{k}) + " = " + item
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("type %T is not supported in toml", v)
+}
+
+// checkPath returns an error if the path is in an array of tables or under a value
+func checkPath(entries []tomlEntry, path []string, key string) error {
+	for _, e := range entries {
+		switch {
+		case e.table && e.array && hasPrefix(path, e.path):
+			return fmt.Errorf("can not change %q, the array of tables are not supported", key)
+		case !e.table && len(e.path) < len(path) && hasPrefix(path, e.path):
+			return fmt.Errorf("can not change %q, the parent key is not a table", key)
+		}
+	}
+	return nil
+}
+
+// insertPoint finds the table for the new key, the deepest table that is a parent of the key,
+// and returns the offset after its last key and the path of the table. if the key is the first
+// key of the root table and there are other tables, the blank is true for a new line after it
+func (te *tomlEditor) insertPoint(entries []tomlEntry, path []string) (int, []string, bool) {
+	var table []string
+	header := -1
+	for i, e := range entries {
+		if e.table && !e.array && len(e.path) < len(path) && hasPrefix(path, e.path) && len(e.path) > len(table) {
+			table, header = e.path, i
+		}
+	}
+
+	if header >= 0 {
+		at := entries[header].lineEnd
+		for _, e := range entries[header+1:] {
+			if e.table {
+				break
+			}
+			at = e.lineEnd
+		}
+		return at, table, false
+	}
+
+	// The root table
+	at := -1
+	for _, e := range entries {
+		if e.table {
+			break
+		}
+		at = e.lineEnd
+	}
+	if at >= 0 {
+		return at, nil, false
+	}
+	for _, e := range entries {
+		if e.table {
+			// Before the comments of the first table
+			at = e.lineStart
+			for at > 0 {
+				prev := strings.LastIndex(te.text[:at-1], "\n") + 1
+				if !isComment(te.text[prev:at]) {
+					break
+				}
+				at = prev
+			}
+			return at, nil, true
+		}
+	}
+	return len(te.text), nil, false
+}
+
+func (te *tomlEditor) Set(key string, value interface{}) error {
+	path, err := onion.SplitKey(key, ".")
+	if err != nil {
+		return err
+	}
+	entries, err := parseTOML(te.text)
+	if err != nil {
+		return err
+	}
+	if err := checkPath(entries, path, key); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !hasPrefix(e.path, path) || len(e.path) != len(path) {
+			continue
+		}
+		if e.table {
+			return fmt.Errorf("can not set %q, it is a table", key)
+		}
+		v, err := tomlValue(value, te.text[e.valStart:e.valEnd])
+		if err != nil {
+			return err
+		}
+		return te.update(te.text[:e.valStart] + v + te.text[e.valEnd:])
+	}
+
+	v, err := tomlValue(value, "")
+	if err != nil {
+		return err
+	}
+	at, table, blank := te.insertPoint(entries, path)
+	line := tomlKey(path[len(table):]) + " = " + v + te.eol
+	before, after := te.text[:at], te.text[at:]
+	if before != "" && !strings.HasSuffix(before, "\n") {
+		line = te.eol + line
+	}
+	if blank {
+		line += te.eol
+	}
+	return te.update(before + line + after)
+}
+
+func (te *tomlEditor) Delete(key string) error {
+	path, err := onion.SplitKey(key, ".")
+	if err != nil {
+		return err
+	}
+	entries, err := parseTOML(te.text)
+	if err != nil {
+		return err
+	}
+	if err := checkPath(entries, path, key); err != nil {
+		return err
+	}
+
+	// Remove all the keys and the tables under the path, the keys in a removed table are
+	// removed with the table
+	var ranges [][2]int
+	skip := 0
+	for i, e := range entries {
+		if !hasPrefix(e.path, path) || e.lineStart < skip {
+			continue
+		}
+		end := e.lineEnd
+		if e.table {
+			end = len(te.text)
+			for _, next := range entries[i+1:] {
+				if next.table {
+					end = next.lineStart
+					break
+				}
+			}
+			skip = end
+		}
+		ranges = append(ranges, [2]int{e.lineStart, end})
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	s := te.text
+	for i := len(ranges) - 1; i >= 0; i-- {
+		s = s[:ranges[i][0]] + s[ranges[i][1]:]
+	}
+	return te.update(s)
+}
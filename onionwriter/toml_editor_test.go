@@ -0,0 +1,143 @@
+package onionwriter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const tomlDoc = `# The application config
+title = 'app'   # the title
+
+# The database
+[db]
+host = "localhost" # the host
+ports = [
+  8000,
+  8001, # second
+]
+created = 1979-05-27 07:32:00Z
+
+[db.pool]
+max = 10
+
+[[servers]]
+name = "a"
+
+[log]
+level = "info"
+`
+
+func TestTOMLEditor(t *testing.T) {
+	Convey("Edit a toml document", t, func() {
+		e, err := NewEditor(strings.NewReader(tomlDoc), "toml")
+		So(err, ShouldBeNil)
+
+		Convey("change the values in place", func() {
+			So(e.Set("title", "new"), ShouldBeNil)
+			So(e.Set("db.host", "db.local"), ShouldBeNil)
+			So(e.Set("db.ports", []int{1, 2}), ShouldBeNil)
+			So(e.Set("db.created", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)), ShouldBeNil)
+			So(e.Set("db.pool.max", 20.5), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+title = 'new'   # the title
+
+# The database
+[db]
+host = "db.local" # the host
+ports = [1, 2]
+created = 2020-01-02T03:04:05Z
+
+[db.pool]
+max = 20.5
+
+[[servers]]
+name = "a"
+
+[log]
+level = "info"
+`)
+		})
+
+		Convey("add the new keys", func() {
+			So(e.Set("version", 2), ShouldBeNil)
+			So(e.Set("db.user", "root"), ShouldBeNil)
+			So(e.Set("db.pool.min", 1), ShouldBeNil)
+			So(e.Set("cache.ttl", "1m"), ShouldBeNil)
+			So(e.Set("log.tags", map[string]interface{}{"app": "onion", "the env": "dev"}), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+title = 'app'   # the title
+version = 2
+cache.ttl = "1m"
+
+# The database
+[db]
+host = "localhost" # the host
+ports = [
+  8000,
+  8001, # second
+]
+created = 1979-05-27 07:32:00Z
+user = "root"
+
+[db.pool]
+max = 10
+min = 1
+
+[[servers]]
+name = "a"
+
+[log]
+level = "info"
+tags = { app = "onion", "the env" = "dev" }
+`)
+		})
+
+		Convey("delete the keys and the tables", func() {
+			So(e.Delete("db.ports"), ShouldBeNil)
+			So(e.Delete("db.pool"), ShouldBeNil)
+			So(e.Delete("log"), ShouldBeNil)
+			So(e.Delete("missing"), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+title = 'app'   # the title
+
+# The database
+[db]
+host = "localhost" # the host
+created = 1979-05-27 07:32:00Z
+
+[[servers]]
+name = "a"
+
+`)
+		})
+
+		Convey("the invalid changes", func() {
+			So(e.Set("servers.name", "b"), ShouldNotBeNil)
+			So(e.Set("db.host.name", "x"), ShouldNotBeNil)
+			So(e.Set("db", 1), ShouldNotBeNil)
+			So(e.Set("title", nil), ShouldNotBeNil)
+			So(e.Set("title", struct{}{}), ShouldNotBeNil)
+			So(e.Delete("db.host.name"), ShouldNotBeNil)
+			So(string(e.Bytes()), ShouldEqual, tomlDoc)
+		})
+	})
+
+	Convey("Edit a toml document without root keys", t, func() {
+		e, err := NewEditor(strings.NewReader("# The config\n\n[db]\nhost = \"a\"\n"), "toml")
+		So(err, ShouldBeNil)
+		So(e.Set("title", "x\ty"), ShouldBeNil)
+		So(string(e.Bytes()), ShouldEqual, "# The config\n\ntitle = \"x\\ty\"\n\n[db]\nhost = \"a\"\n")
+
+		e, err = NewEditor(strings.NewReader(""), "toml")
+		So(err, ShouldBeNil)
+		So(e.Set("a.b", 1), ShouldBeNil)
+		So(e.Set("a.c", true), ShouldBeNil)
+		So(string(e.Bytes()), ShouldEqual, "a.b = 1\na.c = true\n")
+
+		_, err = NewEditor(strings.NewReader("a = "), "toml")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,462 @@
+package onionwriter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/goraz/onion"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlEditor edits the text of the document, the yaml.v3 nodes are used only to find the position
+// of the keys. only the changed keys are written again, so the rest of the document is not touched
+type yamlEditor struct {
+	lines []string
+	eol   string
+
+	// The style of the document
+	unit       int
+	indentless bool
+}
+
+func newYAMLEditor(s string) (*yamlEditor, error) {
+	ye := &yamlEditor{}
+	ye.lines, ye.eol = splitLines(s)
+	root, err := ye.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	ye.unit = 2
+	if root != nil {
+		ye.detectStyle(root)
+	}
+	return ye, nil
+}
+
+func (ye *yamlEditor) Bytes() []byte {
+	return []byte(strings.Join(ye.lines, ye.eol))
+}
+
+// parse returns the root mapping of the first document, it is nil for an empty document
+func (ye *yamlEditor) parse() (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(strings.Join(ye.lines, "\n")), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	switch {
+	case root.Kind == yaml.MappingNode:
+		return root, nil
+	case root.Kind == yaml.ScalarNode && root.ShortTag() == "!!null":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("the yaml document is not a map")
+}
+
+// detectStyle finds the indentation of the document and if the lists are indented under their key
+func (ye *yamlEditor) detectStyle(root *yaml.Node) {
+	unit, seq := 0, false
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind != yaml.MappingNode || n.Style&yaml.FlowStyle != 0 {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if v.Line > k.Line && v.Style&yaml.FlowStyle == 0 {
+				switch {
+				case unit == 0 && v.Kind == yaml.MappingNode && len(v.Content) > 0:
+					unit = v.Content[0].Column - k.Column
+				case !seq && v.Kind == yaml.SequenceNode:
+					seq = true
+					ye.indentless = v.Column == k.Column
+				}
+			}
+			walk(v)
+		}
+	}
+	walk(root)
+	if unit > 0 {
+		ye.unit = unit
+	}
+}
+
+// update replaces the document if the result is a valid yaml
+func (ye *yamlEditor) update(lines []string) error {
+	old := ye.lines
+	ye.lines = lines
+	if _, err := ye.parse(); err != nil {
+		ye.lines = old
+		return fmt.Errorf("the change makes an invalid yaml: %w", err)
+	}
+	return nil
+}
+
+func (ye *yamlEditor) render(n *yaml.Node) ([]string, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(ye.unit)
+	if err := enc.Encode(n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), nil
+}
+
+func findKey(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null"
+}
+
+// nested creates the maps for the path with the value in the last key
+func nested(v *yaml.Node, path ...string) *yaml.Node {
+	for i := len(path) - 1; i >= 0; i-- {
+		v = &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Tag:     "!!map",
+			Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[i]}, v},
+		}
+	}
+	return v
+}
+
+// keepStyle copies the style of the old value to the new one, like the quotes and the flow style
+func keepStyle(old, n *yaml.Node) {
+	n.Anchor = old.Anchor
+	switch {
+	case old.Kind == yaml.ScalarNode && n.Kind == yaml.ScalarNode:
+		quote := old.Style & (yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle)
+		if old.ShortTag() == "!!str" && n.ShortTag() == "!!str" && quote != 0 && !strings.Contains(n.Value, "\n") {
+			n.Style = quote
+		}
+	case old.Kind == n.Kind && old.Style&yaml.FlowStyle != 0:
+		n.Style |= yaml.FlowStyle
+	}
+}
+
+// runeOffset converts the column of the node (in runes) to the byte offset in the line
+func runeOffset(line string, col int) int {
+	for i := range line {
+		if col == 0 {
+			return i
+		}
+		col--
+	}
+	return len(line)
+}
+
+// colon returns the offset after the colon of the key in its line
+func (ye *yamlEditor) colon(k *yaml.Node) (int, error) {
+	line := ye.lines[k.Line-1]
+	i := runeOffset(line, k.Column-1)
+	switch k.Style & (yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle) {
+	case yaml.DoubleQuotedStyle:
+		for i++; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' {
+				i++
+			}
+		}
+		i++
+	case yaml.SingleQuotedStyle:
+		for i++; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				break
+			}
+		}
+		i++
+	default:
+		i += len(k.Value)
+	}
+
+	for ; i < len(line); i++ {
+		switch line[i] {
+		case ' ', '\t':
+		case ':':
+			return i + 1, nil
+		default:
+			i = len(line)
+		}
+	}
+	return 0, fmt.Errorf("the key %q is not supported by the editor", k.Value)
+}
+
+// end returns the last line of the value of the key in a block map, the comments and the empty
+// lines after the value are not included
+func (ye *yamlEditor) end(k, v *yaml.Node) int {
+	indent := k.Column - 1
+	last := k.Line - 1
+	for i := k.Line; i < len(ye.lines); i++ {
+		line := ye.lines[i]
+		if isBlank(line) || isComment(line) {
+			continue
+		}
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...") {
+			break
+		}
+		ind := indentOf(line)
+		if ind > indent {
+			last = i
+			continue
+		}
+		// The lists without indentation, like "key:\n- item"
+		if ind == indent && v.Kind == yaml.SequenceNode && v.Line > k.Line && strings.HasPrefix(line[ind:], "-") {
+			last = i
+			continue
+		}
+		break
+	}
+	return last
+}
+
+// replace writes the new value of the key, if the key is nil the whole document is replaced
+func (ye *yamlEditor) replace(k, v, n *yaml.Node) error {
+	rendered, err := ye.render(n)
+	if err != nil {
+		return err
+	}
+	if k == nil {
+		return ye.update(append(rendered, ""))
+	}
+
+	colon, err := ye.colon(k)
+	if err != nil {
+		return err
+	}
+	first, last := k.Line-1, ye.end(k, v)
+	line := ye.lines[first]
+	sep, comment := " ", ""
+	if v.Line == k.Line {
+		sep = line[colon:runeOffset(line, v.Column-1)]
+		c := v.LineComment
+		if c == "" {
+			c = k.LineComment
+		}
+		if c != "" && last == first && strings.HasSuffix(strings.TrimRight(line, " \t"), c) {
+			// Keep the space before the comment
+			at := strings.LastIndex(line, c)
+			comment = line[len(strings.TrimRight(line[:at], " \t")):]
+		}
+	} else if rest := strings.TrimSpace(line[colon:]); strings.HasPrefix(rest, "#") {
+		comment = " " + rest
+	}
+	head := line[:colon]
+
+	var lines []string
+	switch {
+	case len(rendered) == 1 && isInline(n):
+		lines = append(lines, head+sep+rendered[0]+comment)
+	case strings.HasPrefix(rendered[0], "|") || strings.HasPrefix(rendered[0], ">"):
+		lines = append(lines, head+sep+rendered[0]+comment)
+		lines = append(lines, indentLines(rendered[1:], k.Column-1)...)
+	default:
+		indent := k.Column - 1 + ye.unit
+		if n.Kind == yaml.SequenceNode && ye.indentless {
+			indent = k.Column - 1
+		}
+		if n.Anchor != "" && rendered[0] == "&"+n.Anchor {
+			// The anchor of a block value stays in the line of the key
+			head += " " + rendered[0]
+			rendered = rendered[1:]
+		}
+		lines = append(lines, head+comment)
+		lines = append(lines, indentLines(rendered, indent)...)
+	}
+
+	res := append([]string{}, ye.lines[:first]...)
+	res = append(res, lines...)
+	return ye.update(append(res, ye.lines[last+1:]...))
+}
+
+// isInline returns true for the values that are written in the line of their key, the block
+// lists and maps are written in the lines below the key even with one item
+func isInline(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode || n.Kind == yaml.AliasNode || n.Style&yaml.FlowStyle != 0 || len(n.Content) == 0
+}
+
+func indentLines(lines []string, indent int) []string {
+	res := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if l != "" {
+			l = strings.Repeat(" ", indent) + l
+		}
+		res = append(res, l)
+	}
+	return res
+}
+
+// insert adds the lines after the line
+func (ye *yamlEditor) insert(after int, lines []string) error {
+	res := append([]string{}, ye.lines[:after+1]...)
+	res = append(res, lines...)
+	return ye.update(append(res, ye.lines[after+1:]...))
+}
+
+// yamlPath is the result of walking a path in the document. m is the map of the last found key
+// and k is the key of that map (nil for the root), idx is the index of the key at depth in the map
+// or -1 if it is not found. the first flow node in the path is kept, the changes in the flow nodes
+// are written by rendering the whole flow node again
+type yamlPath struct {
+	k, m    *yaml.Node
+	idx     int
+	depth   int
+	flowK   *yaml.Node
+	flowV   *yaml.Node
+	notAMap bool
+}
+
+// walkYAML finds the path in the document, it stops at the first missing key or the first value
+// that is not a map
+func walkYAML(root *yaml.Node, path []string) yamlPath {
+	p := yamlPath{m: root, idx: -1}
+	if root.Style&yaml.FlowStyle != 0 {
+		p.flowV = root
+	}
+	for i := range path {
+		p.depth = i
+		p.idx = findKey(p.m, path[i])
+		if p.idx < 0 || i == len(path)-1 {
+			return p
+		}
+		k, v := p.m.Content[p.idx], p.m.Content[p.idx+1]
+		if v.Kind != yaml.MappingNode {
+			p.notAMap = true
+			return p
+		}
+		if v.Style&yaml.FlowStyle != 0 && p.flowV == nil {
+			p.flowK, p.flowV = k, v
+		}
+		p.k, p.m = k, v
+	}
+	return p
+}
+
+// encodeNode converts the value to a node, yaml.v3 panics on the types that it can not encode
+func encodeNode(v interface{}) (n *yaml.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = nil, fmt.Errorf("yaml: %v", r)
+		}
+	}()
+
+	n = &yaml.Node{}
+	err = n.Encode(v)
+	return n, err
+}
+
+func (ye *yamlEditor) Set(key string, value interface{}) error {
+	path, err := onion.SplitKey(key, ".")
+	if err != nil {
+		return err
+	}
+	n, err := encodeNode(value)
+	if err != nil {
+		return err
+	}
+
+	root, err := ye.parse()
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		rendered, err := ye.render(nested(n, path...))
+		if err != nil {
+			return err
+		}
+		last := len(ye.lines) - 1
+		for last >= 0 && isBlank(ye.lines[last]) {
+			last--
+		}
+		return ye.insert(last, rendered)
+	}
+
+	p := walkYAML(root, path)
+	if p.notAMap {
+		v := p.m.Content[p.idx+1]
+		if !isNull(v) {
+			return fmt.Errorf("can not set %q, the parent key is not a map", key)
+		}
+		// The empty parent, like "key:"
+		n = nested(n, path[p.depth+1:]...)
+		p.depth = len(path) - 1
+	}
+
+	if p.idx >= 0 && p.depth == len(path)-1 {
+		k, v := p.m.Content[p.idx], p.m.Content[p.idx+1]
+		keepStyle(v, n)
+		if p.flowV == nil {
+			return ye.replace(k, v, n)
+		}
+		p.m.Content[p.idx+1] = n
+		return ye.replace(p.flowK, p.flowV, p.flowV)
+	}
+
+	// Add the missing keys to the map
+	pair := nested(n, path[p.depth:]...)
+	if p.flowV != nil || len(p.m.Content) == 0 {
+		p.m.Content = append(p.m.Content, pair.Content...)
+		if p.flowV == nil {
+			return ye.replace(p.k, p.m, p.m)
+		}
+		return ye.replace(p.flowK, p.flowV, p.flowV)
+	}
+	rendered, err := ye.render(pair)
+	if err != nil {
+		return err
+	}
+	lastK := p.m.Content[len(p.m.Content)-2]
+	return ye.insert(ye.end(lastK, p.m.Content[len(p.m.Content)-1]), indentLines(rendered, lastK.Column-1))
+}
+
+func (ye *yamlEditor) Delete(key string) error {
+	path, err := onion.SplitKey(key, ".")
+	if err != nil {
+		return err
+	}
+	root, err := ye.parse()
+	if err != nil || root == nil {
+		return err
+	}
+
+	p := walkYAML(root, path)
+	if p.idx < 0 || p.notAMap || p.depth != len(path)-1 {
+		return nil
+	}
+
+	if p.flowV != nil {
+		p.m.Content = append(p.m.Content[:p.idx], p.m.Content[p.idx+2:]...)
+		return ye.replace(p.flowK, p.flowV, p.flowV)
+	}
+	if len(p.m.Content) == 2 && p.k != nil {
+		// Keep the parent as an empty map
+		return ye.replace(p.k, p.m, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Style: yaml.FlowStyle})
+	}
+
+	k, v := p.m.Content[p.idx], p.m.Content[p.idx+1]
+	first, last := k.Line-1, ye.end(k, v)
+	// The comment lines just before the key are removed too
+	for c := strings.Count(k.HeadComment, "\n") + 1; k.HeadComment != "" && c > 0 && first > 0 && isComment(ye.lines[first-1]); c-- {
+		first--
+	}
+	res := append([]string{}, ye.lines[:first]...)
+	return ye.update(append(res, ye.lines[last+1:]...))
+}
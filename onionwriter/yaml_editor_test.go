@@ -0,0 +1,161 @@
+package onionwriter
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const yamlDoc = `# The application config
+
+# The database
+db:
+  host: "localhost"   # the host
+  port: 5432
+
+  # The pool
+  pool: {min: 1, max: 10}
+tags:
+- a
+- b
+empty:
+anchor: &x 1
+alias: *x
+`
+
+func TestYAMLEditor(t *testing.T) {
+	Convey("Edit a yaml document", t, func() {
+		e, err := NewEditor(strings.NewReader(yamlDoc), "yaml")
+		So(err, ShouldBeNil)
+
+		Convey("change the values in place", func() {
+			So(e.Set("db.host", "db.local"), ShouldBeNil)
+			So(e.Set("db.port", 6543), ShouldBeNil)
+			So(e.Set("db.pool.max", 20), ShouldBeNil)
+			So(e.Set("tags", []string{"x", "z"}), ShouldBeNil)
+			So(e.Set("anchor", 2), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+
+# The database
+db:
+  host: "db.local"   # the host
+  port: 6543
+
+  # The pool
+  pool: {min: 1, max: 20}
+tags:
+- x
+- z
+empty:
+anchor: &x 2
+alias: *x
+`)
+		})
+
+		Convey("change the values to the block values with one item", func() {
+			So(e.Set("db.port", []string{"x"}), ShouldBeNil)
+			So(e.Set("anchor", map[string]interface{}{"k": "v"}), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+
+# The database
+db:
+  host: "localhost"   # the host
+  port:
+  - x
+
+  # The pool
+  pool: {min: 1, max: 10}
+tags:
+- a
+- b
+empty:
+anchor: &x
+  k: v
+alias: *x
+`)
+		})
+
+		Convey("add the new keys", func() {
+			So(e.Set("db.user", "root"), ShouldBeNil)
+			So(e.Set("db.pool.idle", "1m"), ShouldBeNil)
+			So(e.Set("empty.a.b", true), ShouldBeNil)
+			So(e.Set("log.level", "debug"), ShouldBeNil)
+			So(e.Set("db.note", "multi\nline"), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+
+# The database
+db:
+  host: "localhost"   # the host
+  port: 5432
+
+  # The pool
+  pool: {min: 1, max: 10, idle: 1m}
+  user: root
+  note: |-
+    multi
+    line
+tags:
+- a
+- b
+empty:
+  a:
+    b: true
+anchor: &x 1
+alias: *x
+log:
+  level: debug
+`)
+		})
+
+		Convey("delete the keys", func() {
+			So(e.Delete("db.pool"), ShouldBeNil)
+			So(e.Delete("db.host"), ShouldBeNil)
+			So(e.Delete("alias"), ShouldBeNil)
+			So(e.Delete("missing.key"), ShouldBeNil)
+			So(e.Delete("tags.a"), ShouldBeNil)
+			So(string(e.Bytes()), ShouldEqual, `# The application config
+
+# The database
+db:
+  port: 5432
+
+tags:
+- a
+- b
+empty:
+anchor: &x 1
+`)
+			So(e.Delete("db.port"), ShouldBeNil)
+			So(string(e.Bytes()), ShouldStartWith, "# The application config\n\n# The database\ndb: {}\n\ntags:")
+		})
+
+		Convey("the invalid changes", func() {
+			So(e.Set("db.host.name", "x"), ShouldNotBeNil)
+			So(e.Set("db..host", "x"), ShouldNotBeNil)
+			So(e.Set("anchor", func() {}), ShouldNotBeNil)
+			So(string(e.Bytes()), ShouldEqual, yamlDoc)
+		})
+	})
+
+	Convey("Edit an empty yaml document", t, func() {
+		e, err := NewEditor(strings.NewReader("# only a comment\n"), "yml")
+		So(err, ShouldBeNil)
+		So(e.Set("a.b", 1), ShouldBeNil)
+		So(e.Set("a.c", "x"), ShouldBeNil)
+		So(e.Set("d", 4), ShouldBeNil)
+		So(string(e.Bytes()), ShouldEqual, "# only a comment\na:\n  b: 1\n  c: x\nd: 4\n")
+
+		_, err = NewEditor(strings.NewReader("- a\n- b\n"), "yaml")
+		So(err, ShouldNotBeNil)
+		_, err = NewEditor(strings.NewReader("a: [\n"), "yaml")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Keep the indentation of the document", t, func() {
+		e, err := NewEditor(strings.NewReader("db:\r\n    host: localhost\r\n"), "yaml")
+		So(err, ShouldBeNil)
+		So(e.Set("db.hosts", []string{"a", "b"}), ShouldBeNil)
+		So(string(e.Bytes()), ShouldEqual, "db:\r\n    host: localhost\r\n    hosts:\r\n        - a\r\n        - b\r\n")
+	})
+}
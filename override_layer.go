@@ -0,0 +1,225 @@
+package onion
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxOverrideEvents = 1000
+
+// ErrNoOverride is returned when the deleted key has no override
+var ErrNoOverride = errors.New("the key has no override")
+
+// Override actions in the audit trail
+const (
+	OverrideSet    = "set"
+	OverrideDelete = "delete"
+	OverrideExpire = "expire"
+)
+
+// OverrideEvent is one change in the override layer audit trail
+type OverrideEvent struct {
+	Time   time.Time
+	Who    string
+	Action string
+	Key    string
+	Value  interface{}
+	// TTL is the ttl of the set action, zero means no expiry
+	TTL time.Duration
+}
+
+type override struct {
+	value   interface{}
+	expires time.Time
+	timer   *time.Timer
+}
+
+// OverrideLayer is an in memory layer to override the values at runtime, like lowering a rate
+// limit during an incident. it should be the last layer of the config
+type OverrideLayer struct {
+	ctx       context.Context
+	delimiter string
+
+	lock      sync.RWMutex
+	overrides map[string]*override
+	events    []OverrideEvent
+	data      map[string]interface{}
+	notify    chan struct{}
+	c         chan map[string]interface{}
+}
+
+// Load returns the current overrides
+func (ol *OverrideLayer) Load() map[string]interface{} {
+	ol.lock.RLock()
+	defer ol.lock.RUnlock()
+
+	return ol.data
+}
+
+// Watch returns the channel of the changes
+func (ol *OverrideLayer) Watch() <-chan map[string]interface{} {
+	return ol.c
+}
+
+// Set overrides the key with the value, the override is removed after the ttl (zero means never)
+func (ol *OverrideLayer) Set(key string, value interface{}, ttl time.Duration) {
+	ol.SetBy("", key, value, ttl)
+}
+
+// SetBy is the Set with the name of the user (or tool) for the audit trail
+func (ol *OverrideLayer) SetBy(who, key string, value interface{}, ttl time.Duration) {
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	ol.remove(key)
+	ov := &override{value: value}
+	if ttl > 0 {
+		ov.expires = time.Now().Add(ttl)
+		ov.timer = time.AfterFunc(ttl, func() {
+			ol.expire(key, ov)
+		})
+	}
+	ol.overrides[key] = ov
+	ol.record(OverrideEvent{Who: who, Action: OverrideSet, Key: key, Value: value, TTL: ttl})
+	ol.publish()
+}
+
+// Delete removes the override of the key, it returns ErrNoOverride if there is no override
+func (ol *OverrideLayer) Delete(key string) error {
+	return ol.DeleteBy("", key)
+}
+
+// DeleteBy is the Delete with the name of the user (or tool) for the audit trail
+func (ol *OverrideLayer) DeleteBy(who, key string) error {
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	if !ol.remove(key) {
+		return ErrNoOverride
+	}
+	ol.record(OverrideEvent{Who: who, Action: OverrideDelete, Key: key})
+	ol.publish()
+	return nil
+}
+
+// Overrides returns the current overrides and their expiry time, zero time means no expiry
+func (ol *OverrideLayer) Overrides() map[string]time.Time {
+	ol.lock.RLock()
+	defer ol.lock.RUnlock()
+
+	res := make(map[string]time.Time, len(ol.overrides))
+	for k, ov := range ol.overrides {
+		res[k] = ov.expires
+	}
+	return res
+}
+
+// Audit returns the last changes (set, delete and expire) in order
+func (ol *OverrideLayer) Audit() []OverrideEvent {
+	ol.lock.RLock()
+	defer ol.lock.RUnlock()
+
+	return append([]OverrideEvent{}, ol.events...)
+}
+
+func (ol *OverrideLayer) expire(key string, ov *override) {
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	// The key is set again or deleted
+	if ol.overrides[key] != ov {
+		return
+	}
+	delete(ol.overrides, key)
+	ol.record(OverrideEvent{Action: OverrideExpire, Key: key})
+	ol.publish()
+}
+
+func (ol *OverrideLayer) remove(key string) bool {
+	ov, ok := ol.overrides[key]
+	if !ok {
+		return false
+	}
+	if ov.timer != nil {
+		ov.timer.Stop()
+	}
+	delete(ol.overrides, key)
+	return true
+}
+
+func (ol *OverrideLayer) record(e OverrideEvent) {
+	e.Time = time.Now()
+	ol.events = append(ol.events, e)
+	if len(ol.events) > maxOverrideEvents {
+		ol.events = ol.events[len(ol.events)-maxOverrideEvents:]
+	}
+}
+
+// publish builds the data and notifies the sender, the lock should be held
+func (ol *OverrideLayer) publish() {
+	keys := make([]string, 0, len(ol.overrides))
+	for k := range ol.overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var data map[string]interface{}
+	for _, k := range keys {
+		data = buildMap(data, ol.overrides[k].value, strings.Split(k, ol.delimiter)...)
+	}
+	ol.data = data
+
+	select {
+	case ol.notify <- struct{}{}:
+	default:
+	}
+}
+
+// send sends the latest data on each change, so the order of the changes is kept
+func (ol *OverrideLayer) send() {
+	for {
+		select {
+		case <-ol.ctx.Done():
+			ol.lock.Lock()
+			defer ol.lock.Unlock()
+			for _, ov := range ol.overrides {
+				if ov.timer != nil {
+					ov.timer.Stop()
+				}
+			}
+			return
+		case <-ol.notify:
+			select {
+			case ol.c <- ol.Load():
+			case <-ol.ctx.Done():
+			}
+		}
+	}
+}
+
+// NewOverrideLayerContext creates an empty override layer, the keys are split by the delimiter
+// (empty means "."). the expired overrides are removed until the context is done
+func NewOverrideLayerContext(ctx context.Context, delimiter string) *OverrideLayer {
+	if delimiter == "" {
+		delimiter = "."
+	}
+	ol := &OverrideLayer{
+		ctx:       ctx,
+		delimiter: delimiter,
+		overrides: make(map[string]*override),
+		notify:    make(chan struct{}, 1),
+		c:         make(chan map[string]interface{}),
+	}
+
+	go ol.send()
+	return ol
+}
+
+// NewOverrideLayer creates a new override layer, see NewOverrideLayerContext
+func NewOverrideLayer(delimiter string) *OverrideLayer {
+	return NewOverrideLayerContext(context.Background(), delimiter)
+}
@@ -0,0 +1,77 @@
+package onion
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func waitForInt(o *Onion, key string, value int) bool {
+	for i := 0; i < 100; i++ {
+		if o.GetInt(key) == value {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestOverrideLayer(t *testing.T) {
+	Convey("Override the values at runtime", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ol := NewOverrideLayerContext(ctx, "")
+		o := NewContext(ctx, NewMapLayer(map[string]interface{}{
+			"rate": map[string]interface{}{"limit": 100, "burst": 10},
+		}), ol)
+		So(o.GetInt("rate.limit"), ShouldEqual, 100)
+
+		ol.SetBy("alice", "rate.limit", 10, 0)
+		So(waitForInt(o, "rate.limit", 10), ShouldBeTrue)
+		So(o.GetInt("rate.burst"), ShouldEqual, 10)
+
+		ol.Set("rate.burst", 1, 50*time.Millisecond)
+		So(waitForInt(o, "rate.burst", 1), ShouldBeTrue)
+		So(ol.Overrides(), ShouldHaveLength, 2)
+		So(ol.Overrides()["rate.limit"].IsZero(), ShouldBeTrue)
+		So(ol.Overrides()["rate.burst"].IsZero(), ShouldBeFalse)
+
+		// Expired
+		watch := o.ReloadWatch()
+		<-watch
+		So(waitForInt(o, "rate.burst", 10), ShouldBeTrue)
+		So(ol.Overrides(), ShouldHaveLength, 1)
+
+		So(ol.DeleteBy("bob", "rate.limit"), ShouldBeNil)
+		So(ol.Delete("rate.limit"), ShouldEqual, ErrNoOverride)
+		So(waitForInt(o, "rate.limit", 100), ShouldBeTrue)
+
+		events := ol.Audit()
+		So(events, ShouldHaveLength, 4)
+		So(events[0].Who, ShouldEqual, "alice")
+		So(events[0].Action, ShouldEqual, OverrideSet)
+		So(events[0].Value, ShouldEqual, 10)
+		So(events[1].TTL, ShouldEqual, 50*time.Millisecond)
+		So(events[2].Action, ShouldEqual, OverrideExpire)
+		So(events[2].Key, ShouldEqual, "rate.burst")
+		So(events[3].Who, ShouldEqual, "bob")
+		So(events[3].Action, ShouldEqual, OverrideDelete)
+	})
+
+	Convey("Set again cancels the expiry", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ol := NewOverrideLayerContext(ctx, "/")
+		o := NewContext(ctx, ol)
+		ol.Set("a/b", 1, 20*time.Millisecond)
+		ol.Set("a/b", 2, 0)
+		So(waitForInt(o, "a.b", 2), ShouldBeTrue)
+		time.Sleep(50 * time.Millisecond)
+		So(o.GetInt("a.b"), ShouldEqual, 2)
+		So(ol.Audit(), ShouldHaveLength, 2)
+	})
+}
@@ -0,0 +1,166 @@
+package onion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type transformLayer struct {
+	inner     Layer
+	transform func(map[string]interface{}) map[string]interface{}
+	secrets   func([][]string) [][]string
+
+	lock sync.RWMutex
+	data map[string]interface{}
+	c    chan map[string]interface{}
+}
+
+// reloadTransformLayer is the transform layer for the inner layers which implement the Reloader
+type reloadTransformLayer struct {
+	*transformLayer
+}
+
+func (tl *transformLayer) Load() map[string]interface{} {
+	tl.lock.RLock()
+	defer tl.lock.RUnlock()
+
+	return tl.data
+}
+
+func (tl *transformLayer) Watch() <-chan map[string]interface{} {
+	return tl.c
+}
+
+func (tl *transformLayer) SecretKeys() [][]string {
+	sl, ok := tl.inner.(SecretLayer)
+	if !ok {
+		return nil
+	}
+	return tl.secrets(sl.SecretKeys())
+}
+
+// ReloadLayer reloads the inner layer, the new data is sent through the watch channel
+func (rl reloadTransformLayer) ReloadLayer(ctx context.Context) error {
+	return rl.inner.(Reloader).ReloadLayer(ctx)
+}
+
+func (tl *transformLayer) forward(ctx context.Context) {
+	c := tl.inner.Watch()
+	if c == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-c:
+			if !ok {
+				return
+			}
+			data = tl.transform(data)
+			tl.lock.Lock()
+			tl.data = data
+			tl.lock.Unlock()
+
+			select {
+			case tl.c <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// hasPath checks if the key is under the path (and not the path itself)
+func hasPath(key, path []string) bool {
+	if len(key) <= len(path) {
+		return false
+	}
+	for i := range path {
+		if key[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newTransformLayer(ctx context.Context, l Layer, transform func(map[string]interface{}) map[string]interface{}, secrets func([][]string) [][]string) Layer {
+	tl := &transformLayer{
+		inner:     l,
+		transform: transform,
+		secrets:   secrets,
+		data:      transform(l.Load()),
+		c:         make(chan map[string]interface{}),
+	}
+	go tl.forward(ctx)
+
+	if _, ok := l.(Reloader); ok {
+		return reloadTransformLayer{tl}
+	}
+	return tl
+}
+
+// NewPrefixLayerContext mounts the layer under the prefix, with the prefix "cache", "redis" the key
+// "host" in the layer is "cache.redis.host". the watch updates of the layer are also mounted
+// under the prefix until the context is done, so the layer should not be used anywhere else. an
+// empty layer is not mounted
+func NewPrefixLayerContext(ctx context.Context, l Layer, prefix ...string) Layer {
+	return newTransformLayer(ctx, l, func(data map[string]interface{}) map[string]interface{} {
+		if len(data) == 0 {
+			return nil
+		}
+		for i := len(prefix) - 1; i >= 0; i-- {
+			data = map[string]interface{}{prefix[i]: data}
+		}
+		return data
+	}, func(keys [][]string) [][]string {
+		res := make([][]string, 0, len(keys))
+		for _, k := range keys {
+			res = append(res, append(append([]string{}, prefix...), k...))
+		}
+		return res
+	})
+}
+
+// NewPrefixLayer mounts the layer under the prefix, see NewPrefixLayerContext
+func NewPrefixLayer(l Layer, prefix ...string) Layer {
+	return NewPrefixLayerContext(context.Background(), l, prefix...)
+}
+
+// NewSubLayerContext extracts the subtree at the path of the layer as the root of a new layer, with
+// the path "cache", "redis" the key "cache.redis.host" in the layer is "host". if the path is not a
+// map the layer is empty. the watch updates of the layer are also extracted until the context is
+// done, so the layer should not be used anywhere else
+func NewSubLayerContext(ctx context.Context, l Layer, path ...string) Layer {
+	return newTransformLayer(ctx, l, func(data map[string]interface{}) map[string]interface{} {
+		if len(path) == 0 {
+			return data
+		}
+		v, _ := searchStringMap(data, path...)
+		switch m := v.(type) {
+		case map[string]interface{}:
+			return m
+		case map[interface{}]interface{}:
+			res := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				res[fmt.Sprint(k)] = v
+			}
+			return res
+		}
+		return nil
+	}, func(keys [][]string) [][]string {
+		var res [][]string
+		for _, k := range keys {
+			if hasPath(k, path) {
+				res = append(res, k[len(path):])
+			}
+		}
+		return res
+	})
+}
+
+// NewSubLayer extracts the subtree at the path of the layer, see NewSubLayerContext
+func NewSubLayer(l Layer, path ...string) Layer {
+	return NewSubLayerContext(context.Background(), l, path...)
+}
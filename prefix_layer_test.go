@@ -0,0 +1,93 @@
+package onion
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewPrefixLayer(t *testing.T) {
+	Convey("Mount a layer under a prefix", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		l, err := NewStreamLayerContext(ctx, bytes.NewBufferString(validJSON), "json", nil)
+		So(err, ShouldBeNil)
+		pl := NewPrefixLayerContext(ctx, l, "cache", "redis")
+		_, ok := pl.(Reloader)
+		So(ok, ShouldBeFalse)
+
+		o := NewContext(ctx, NewMapLayer(map[string]interface{}{"string": "root"}), pl)
+		So(o.GetString("string"), ShouldEqual, "root")
+		So(o.GetString("cache.redis.string"), ShouldEqual, "str")
+		So(o.GetInt("cache.redis.number"), ShouldEqual, 100)
+		So(o.GetBool("cache.redis.nested.bool"), ShouldBeTrue)
+
+		watch := o.ReloadWatch()
+		So(l.(*streamLayer).Reload(ctx, bytes.NewBufferString(`{"number": 101}`), "json"), ShouldBeNil)
+		<-watch
+		So(o.GetInt("cache.redis.number"), ShouldEqual, 101)
+		_, ok = o.Get("cache.redis.string")
+		So(ok, ShouldBeFalse)
+
+		So(NewPrefixLayer(NewMapLayer()).Load(), ShouldBeNil)
+	})
+
+	Convey("Forward the reload and the secret keys", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		env := map[string]string{"APP_DB_PASSWORD": "secret", "APP_DB_HOST": "localhost"}
+		source := &syncEnv{env: env}
+		opt := EnvOptions{Prefix: "APP", Source: source, Secrets: []string{"APP_DB_PASSWORD"}}
+		// The watch channel of the inner layer is read by the new layer, so each one has its own
+		l1, err := NewEnvLayerOptions(opt)
+		So(err, ShouldBeNil)
+		l2, err := NewEnvLayerOptions(opt)
+		So(err, ShouldBeNil)
+
+		pl := NewPrefixLayerContext(ctx, l1, "tenant")
+		sl := NewSubLayerContext(ctx, l2, "db")
+		o := NewContext(ctx, pl, sl)
+		So(o.GetString("tenant.db.host"), ShouldEqual, "localhost")
+		So(o.GetString("host"), ShouldEqual, "localhost")
+		So(o.SecretKeys(), ShouldResemble, []string{"tenant.db.password", "password"})
+
+		source.set("APP_DB_HOST", "db.local")
+		res := o.Reload(ctx)
+		So(res, ShouldHaveLength, 2)
+		So(res[0].Err, ShouldBeNil)
+		So(res[1].Err, ShouldBeNil)
+		for i := 0; i < 100 && (o.GetString("tenant.db.host") != "db.local" || o.GetString("host") != "db.local"); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(o.GetString("tenant.db.host"), ShouldEqual, "db.local")
+		So(o.GetString("host"), ShouldEqual, "db.local")
+	})
+}
+
+func TestNewSubLayer(t *testing.T) {
+	Convey("Extract a subtree of a layer", t, func() {
+		l := NewMapLayer(map[string]interface{}{
+			"cache": map[string]interface{}{
+				"redis": map[interface{}]interface{}{
+					"host": "localhost",
+					"pool": map[interface{}]interface{}{"size": 10},
+				},
+				"ttl": 10,
+			},
+		})
+
+		o := New(NewSubLayer(l, "cache", "redis"))
+		So(o.GetString("host"), ShouldEqual, "localhost")
+		So(o.GetInt("pool.size"), ShouldEqual, 10)
+
+		So(New(NewSubLayer(l)).GetInt("cache.ttl"), ShouldEqual, 10)
+		So(NewSubLayer(l, "cache", "ttl").Load(), ShouldBeNil)
+		So(NewSubLayer(l, "unknown").Load(), ShouldBeNil)
+		So(NewSubLayer(l, "cache").(SecretLayer).SecretKeys(), ShouldBeNil)
+	})
+}
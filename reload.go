@@ -0,0 +1,86 @@
+package onion
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// Reloader is an optional interface for the layers that can read their source again on demand,
+// like the file layer or the env layer. the new data is sent to the watch channel.
+type Reloader interface {
+	Layer
+	// ReloadLayer reads the source of the layer again
+	ReloadLayer(ctx context.Context) error
+}
+
+// ReloadResult is the result of reloading one layer
+type ReloadResult struct {
+	Layer Layer
+	Err   error
+}
+
+// notifySignals calls the fn on every signal until the context is done
+func notifySignals(ctx context.Context, fn func(), sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				fn()
+			}
+		}
+	}()
+}
+
+// Reload reloads all the layers in the global config, see Onion.Reload
+func Reload(ctx context.Context) []ReloadResult {
+	return o.Reload(ctx)
+}
+
+// Reload calls the ReloadLayer of all the layers that implement the Reloader interface and returns
+// the result of each of them, in the layers order
+func (o *Onion) Reload(ctx context.Context) []ReloadResult {
+	o.lock.RLock()
+	ll := make([]Layer, len(o.ll))
+	copy(ll, o.ll)
+	o.lock.RUnlock()
+
+	var res []ReloadResult
+	for _, l := range ll {
+		r, ok := l.(Reloader)
+		if !ok {
+			continue
+		}
+		res = append(res, ReloadResult{Layer: l, Err: r.ReloadLayer(ctx)})
+	}
+	return res
+}
+
+// ReloadOnSignal reloads the global config on the signals, see Onion.ReloadOnSignal
+func ReloadOnSignal(ctx context.Context, sigs ...os.Signal) <-chan []ReloadResult {
+	return o.ReloadOnSignal(ctx, sigs...)
+}
+
+// ReloadOnSignal calls Reload on every signal (like syscall.SIGHUP) until the context is done.
+// the result of each reload is sent to the returned channel, if the channel is not read the
+// result is dropped
+func (o *Onion) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) <-chan []ReloadResult {
+	res := make(chan []ReloadResult, 1)
+	notifySignals(ctx, func() {
+		r := o.Reload(ctx)
+		select {
+		case res <- r:
+		default:
+		}
+	}, sigs...)
+
+	return res
+}
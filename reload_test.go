@@ -0,0 +1,61 @@
+package onion
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReload(t *testing.T) {
+	Convey("Reload the reloadable layers", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		f, err := ioutil.TempFile(os.TempDir(), "*.json")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.Remove(f.Name()) }()
+		So(f.Close(), ShouldBeNil)
+		So(ioutil.WriteFile(f.Name(), []byte(`{"file": 1}`), 0600), ShouldBeNil)
+
+		fl, err := NewFileLayerContext(ctx, f.Name(), nil)
+		So(err, ShouldBeNil)
+		env := map[string]string{"APP_ENV": "1"}
+		el, err := NewEnvLayerOptionsContext(ctx, EnvOptions{Prefix: "app", Source: MapEnv(env)})
+		So(err, ShouldBeNil)
+		o := NewContext(ctx, NewMapLayer(map[string]interface{}{"map": 1}), fl, el)
+
+		Convey("on demand", func() {
+			So(ioutil.WriteFile(f.Name(), []byte(`{"file": 2}`), 0600), ShouldBeNil)
+			watch := o.ReloadWatch()
+			res := o.Reload(ctx)
+			So(res, ShouldHaveLength, 2)
+			So(res[0].Layer, ShouldEqual, fl)
+			So(res[0].Err, ShouldBeNil)
+			So(res[1].Layer, ShouldEqual, el)
+			So(res[1].Err, ShouldBeNil)
+			<-watch
+			So(o.GetInt("file"), ShouldEqual, 2)
+
+			So(os.Remove(f.Name()), ShouldBeNil)
+			res = o.Reload(ctx)
+			So(res[0].Err, ShouldNotBeNil)
+			So(o.GetInt("file"), ShouldEqual, 2)
+		})
+
+		Convey("on signal", func() {
+			results := o.ReloadOnSignal(ctx, syscall.SIGUSR2)
+			So(ioutil.WriteFile(f.Name(), []byte(`{"file": 3}`), 0600), ShouldBeNil)
+			watch := o.ReloadWatch()
+			So(syscall.Kill(os.Getpid(), syscall.SIGUSR2), ShouldBeNil)
+			res := <-results
+			So(res, ShouldHaveLength, 2)
+			So(res[0].Err, ShouldBeNil)
+			<-watch
+			So(o.GetInt("file"), ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package onion
+
+import (
+	"context"
+	"sync"
+)
+
+// Sender is the watch channel of a layer. the data is sent from one goroutine, so the changes are
+// in order and a slow reader gets only the latest data. the goroutine is started on the first
+// Watch or Send, so the temporary layers (only loaded) do not start it. the channel is closed when
+// the context is done
+type Sender struct {
+	ctx    context.Context
+	start  sync.Once
+	lock   sync.Mutex
+	data   map[string]interface{}
+	notify chan struct{}
+	c      chan map[string]interface{}
+}
+
+// Send sends the data to the watch channel, it does not block. if the previous data is not sent
+// yet, it is replaced
+func (s *Sender) Send(data map[string]interface{}) {
+	s.lock.Lock()
+	s.data = data
+	s.lock.Unlock()
+
+	s.start.Do(func() { go s.send() })
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Watch returns the channel, it can be returned from the Watch of the layer
+func (s *Sender) Watch() <-chan map[string]interface{} {
+	s.start.Do(func() { go s.send() })
+	return s.c
+}
+
+func (s *Sender) send() {
+	defer close(s.c)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.notify:
+			s.lock.Lock()
+			data := s.data
+			s.lock.Unlock()
+
+			select {
+			case s.c <- data:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// NewSender creates a sender for a layer, the data is sent until the context is done
+func NewSender(ctx context.Context) *Sender {
+	s := &Sender{
+		ctx:    ctx,
+		notify: make(chan struct{}, 1),
+		c:      make(chan map[string]interface{}),
+	}
+
+	return s
+}
@@ -0,0 +1,35 @@
+package onion
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSender(t *testing.T) {
+	Convey("Send the latest data in order", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := NewSender(ctx)
+		for i := 1; i <= 100; i++ {
+			s.Send(map[string]interface{}{"a": i})
+		}
+
+		// The reader may get some of the old data, but the last one is always the latest
+		last := 0
+		for last != 100 {
+			data := <-s.Watch()
+			So(data["a"], ShouldBeGreaterThan, last)
+			last = data["a"].(int)
+		}
+
+		cancel()
+		_, ok := <-s.Watch()
+		So(ok, ShouldBeFalse)
+
+		// Send does not block after the context is done
+		s.Send(map[string]interface{}{"a": 101})
+	})
+}
@@ -0,0 +1,181 @@
+package onion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+var (
+	factoryLock sync.RWMutex
+	factories   = map[string]LayerFactory{
+		"file": fileFactory,
+		"env":  envFactory,
+		"map":  mapFactory,
+	}
+)
+
+// LayerSpec is one layer in the spec document, all the keys except the type and the cipher are the
+// layer options
+type LayerSpec struct {
+	// Type is the registered type of the layer, like file or etcd
+	Type string
+	// Cipher is the cipher referenced by name in the spec, nil if there is no cipher
+	Cipher Cipher
+	// Options are the layer options, so the factory can use the typed getters like GetString
+	Options *Onion
+}
+
+// LayerFactory creates a layer from the spec, the layers with watch should watch until the context
+// is done
+type LayerFactory func(ctx context.Context, spec LayerSpec) (Layer, error)
+
+// RegisterLayerFactory add a new layer factory for the types, file, env and map are registered
+// out of the box and the sub packages register their layers, so a blank import is enough
+func RegisterLayerFactory(f LayerFactory, types ...string) {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+
+	for _, typ := range types {
+		typ := strings.ToLower(typ)
+
+		if _, alreadyExists := factories[typ]; alreadyExists {
+			log.Fatalf("layer factory for type %q is already registered: you can have only one", typ)
+		}
+
+		factories[typ] = f
+	}
+}
+
+// GetLayerFactory returns the layer factory based on its type, it may returns nil if the type is
+// not registered
+func GetLayerFactory(typ string) LayerFactory {
+	factoryLock.RLock()
+	defer factoryLock.RUnlock()
+
+	return factories[strings.ToLower(typ)]
+}
+
+func fileFactory(ctx context.Context, spec LayerSpec) (Layer, error) {
+	return NewFileLayerContext(ctx, spec.Options.GetString("path"), spec.Cipher)
+}
+
+func envFactory(ctx context.Context, spec LayerSpec) (Layer, error) {
+	opt := spec.Options
+	return NewEnvLayerOptionsContext(ctx, EnvOptions{
+		Prefix:       opt.GetString("prefix"),
+		Separator:    opt.GetString("separator"),
+		InferTypes:   opt.GetBool("infer_types"),
+		IndexedLists: opt.GetBool("indexed_lists"),
+		Secrets:      opt.GetStringSlice("secrets"),
+	})
+}
+
+func mapFactory(ctx context.Context, spec LayerSpec) (Layer, error) {
+	v, _ := spec.Options.Get("data")
+	data, err := stringMap(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewMapLayerContext(ctx, data), nil
+}
+
+// stringMap converts the map (with string or interface keys) into the map[string]interface{}, only
+// the first level is changed
+func stringMap(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			res[fmt.Sprint(k)] = v
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%T is not a map", v)
+}
+
+// NewLayerFromSpecContext creates a layer from the spec with the registered factories, the cipher
+// key in the spec is the name of the cipher in the ciphers
+func NewLayerFromSpecContext(ctx context.Context, spec map[string]interface{}, ciphers map[string]Cipher) (Layer, error) {
+	typ, _ := spec["type"].(string)
+	f := GetLayerFactory(typ)
+	if f == nil {
+		return nil, fmt.Errorf("layer type %q is not registered", typ)
+	}
+
+	ls := LayerSpec{Type: typ}
+	if name, ok := spec["cipher"].(string); ok && name != "" {
+		if ls.Cipher = ciphers[name]; ls.Cipher == nil {
+			return nil, fmt.Errorf("cipher %q is not found", name)
+		}
+	}
+
+	options := make(map[string]interface{}, len(spec))
+	for k, v := range spec {
+		if k != "type" && k != "cipher" {
+			options[k] = v
+		}
+	}
+	ls.Options = New(NewMapLayer(options))
+
+	l, err := f(ctx, ls)
+	if err != nil {
+		return nil, fmt.Errorf("layer %q: %w", typ, err)
+	}
+	return l, nil
+}
+
+// FromSpecContext builds the config from a spec document in the format (any registered format),
+// the document has a list of layers with their type and options, in the layers order:
+//
+//	layers:
+//	  - type: file
+//	    path: /etc/app/config.json
+//	    cipher: main
+//	  - type: env
+//	    prefix: APP
+//
+// the cipher is the name of the cipher in the ciphers. the types are registered with the
+// RegisterLayerFactory, blank import the layer packages to register their types
+func FromSpecContext(ctx context.Context, r io.Reader, format string, ciphers map[string]Cipher) (*Onion, error) {
+	dec := GetDecoder(format)
+	if dec == nil {
+		return nil, fmt.Errorf("format %q is not registered", format)
+	}
+	doc, err := dec.Decode(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	specs, ok := doc["layers"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the spec should have a list of layers")
+	}
+
+	layers := make([]Layer, 0, len(specs))
+	for i := range specs {
+		spec, err := stringMap(specs[i])
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+		l, err := NewLayerFromSpecContext(ctx, spec, ciphers)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, l)
+	}
+
+	return NewContext(ctx, layers...), nil
+}
+
+// FromSpec builds the config from a spec document, see FromSpecContext
+func FromSpec(r io.Reader, format string, ciphers map[string]Cipher) (*Onion, error) {
+	return FromSpecContext(context.Background(), r, format, ciphers)
+}
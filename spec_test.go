@@ -0,0 +1,152 @@
+package onion_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/goraz/onion"
+	_ "github.com/goraz/onion/layers/archivelayer"
+	_ "github.com/goraz/onion/layers/configmaplayer"
+	_ "github.com/goraz/onion/layers/directorylayer"
+	_ "github.com/goraz/onion/layers/directorywatchlayer"
+	_ "github.com/goraz/onion/layers/filekeylayer"
+	_ "github.com/goraz/onion/layers/filewatchlayer"
+	_ "github.com/goraz/onion/layers/profilelayer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type replaceCipher struct{}
+
+func (replaceCipher) Decrypt(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(r)
+	return bytes.Replace(b, []byte("encrypted"), []byte("decrypted"), -1), err
+}
+
+func TestFromSpec(t *testing.T) {
+	Convey("Build the config from a spec", t, func() {
+		f, err := ioutil.TempFile("", "onion-spec-*.json")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.Remove(f.Name()) }()
+		_, err = f.WriteString(`{"db": {"host": "localhost", "password": "encrypted"}, "port": 1}`)
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		So(os.Setenv("ONIONSPEC_PORT", "2"), ShouldBeNil)
+		defer func() { _ = os.Unsetenv("ONIONSPEC_PORT") }()
+
+		if GetLayerFactory("spec-test") == nil {
+			RegisterLayerFactory(func(_ context.Context, spec LayerSpec) (Layer, error) {
+				if spec.Options.GetBool("fail") {
+					return nil, errors.New("failed")
+				}
+				return NewMapLayer(map[string]interface{}{"custom": spec.Options.GetInt("value")}), nil
+			}, "spec-test")
+		}
+
+		spec := `{"layers": [
+			{"type": "file", "path": "` + f.Name() + `", "cipher": "main"},
+			{"type": "env", "prefix": "ONIONSPEC", "infer_types": true},
+			{"type": "map", "data": {"db": {"host": "db.local"}}},
+			{"type": "SPEC-TEST", "value": 10}
+		]}`
+		o, err := FromSpec(strings.NewReader(spec), "json", map[string]Cipher{"main": replaceCipher{}})
+		So(err, ShouldBeNil)
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetString("db.password"), ShouldEqual, "decrypted")
+		So(o.GetInt("port"), ShouldEqual, 2)
+		So(o.GetInt("custom"), ShouldEqual, 10)
+
+		for _, spec := range []string{
+			`{"layers": [{"type": "unknown"}]}`,
+			`{"layers": [{"type": "file", "path": "` + f.Name() + `", "cipher": "unknown"}]}`,
+			`{"layers": [{"type": "spec-test", "fail": true}]}`,
+			`{"layers": [{"type": "map", "data": 1}]}`,
+			`{"layers": ["file"]}`,
+			`{"layers": {}}`,
+			`invalid`,
+		} {
+			_, err := FromSpec(strings.NewReader(spec), "json", nil)
+			So(err, ShouldNotBeNil)
+		}
+		_, err = FromSpec(strings.NewReader(spec), "unknown", nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func tarFile(path string, files map[string]string) {
+	f, err := os.Create(path)
+	So(err, ShouldBeNil)
+	defer func() { _ = f.Close() }()
+
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		So(tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}), ShouldBeNil)
+		_, err := tw.Write([]byte(content))
+		So(err, ShouldBeNil)
+	}
+	So(tw.Close(), ShouldBeNil)
+}
+
+// TestLayerFactories builds each registered file based layer from a spec, the options specific to
+// a factory are tested in its own package
+func TestLayerFactories(t *testing.T) {
+	Convey("Build the file based layers from a spec", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		for _, tc := range []struct {
+			typ   string
+			files map[string]string
+			spec  func(dir string) map[string]interface{}
+		}{
+			{"directory", map[string]string{"a.json": `{"factory": "directory"}`},
+				func(dir string) map[string]interface{} { return map[string]interface{}{"path": dir} }},
+			{"directorywatch", map[string]string{"a.json": `{"factory": "directorywatch"}`},
+				func(dir string) map[string]interface{} {
+					return map[string]interface{}{"path": dir, "extensions": []string{"json"}}
+				}},
+			{"filewatch", map[string]string{"a.json": `{"factory": "filewatch"}`},
+				func(dir string) map[string]interface{} {
+					return map[string]interface{}{"path": filepath.Join(dir, "a.json")}
+				}},
+			{"filekey", map[string]string{"factory": "filekey\n"},
+				func(dir string) map[string]interface{} { return map[string]interface{}{"path": dir} }},
+			{"configmap", map[string]string{"factory": "configmap"},
+				func(dir string) map[string]interface{} { return map[string]interface{}{"path": dir} }},
+			{"profile", map[string]string{"config.json": `{"factory": "profile"}`},
+				func(dir string) map[string]interface{} {
+					return map[string]interface{}{"paths": []string{dir}}
+				}},
+			{"archive", nil,
+				func(dir string) map[string]interface{} {
+					tarFile(filepath.Join(dir, "config.tar"), map[string]string{"a.json": `{"factory": "archive"}`})
+					return map[string]interface{}{"path": filepath.Join(dir, "config.tar")}
+				}},
+		} {
+			dir, err := ioutil.TempDir("", "onion-factory-")
+			So(err, ShouldBeNil)
+			defer func() { _ = os.RemoveAll(dir) }()
+			for name, content := range tc.files {
+				So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), ShouldBeNil)
+			}
+
+			layer := tc.spec(dir)
+			layer["type"] = tc.typ
+			spec, err := json.Marshal(map[string]interface{}{"layers": []interface{}{layer}})
+			So(err, ShouldBeNil)
+
+			o, err := FromSpecContext(ctx, bytes.NewReader(spec), "json", nil)
+			So(err, ShouldBeNil)
+			So(o.GetString("factory"), ShouldEqual, tc.typ)
+		}
+	})
+}
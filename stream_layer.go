@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -18,6 +20,11 @@ var (
 	decoders = map[string]Decoder{
 		"json": &jsonDecoder{},
 	}
+
+	encLock  sync.RWMutex
+	encoders = map[string]Encoder{
+		"json": &jsonEncoder{},
+	}
 )
 
 // Cipher is used to decrypt data on loading
@@ -31,9 +38,18 @@ type Decoder interface {
 	Decode(context.Context, io.Reader) (map[string]interface{}, error)
 }
 
+// Encoder is the reverse of the decoder, it writes the config keys into a stream. it is used by the
+// writable layers to persist the data in the original format, json is supported out of the box
+type Encoder interface {
+	Encode(context.Context, io.Writer, map[string]interface{}) error
+}
+
 type jsonDecoder struct {
 }
 
+type jsonEncoder struct {
+}
+
 func decrypt(c Cipher, r io.Reader) (io.Reader, error) {
 	if c == nil {
 		return r, nil
@@ -55,6 +71,12 @@ func (jd *jsonDecoder) Decode(_ context.Context, r io.Reader) (map[string]interf
 	return data, nil
 }
 
+func (je *jsonEncoder) Encode(_ context.Context, w io.Writer, data map[string]interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
 // RegisterDecoder add a new decoder to the system, json is registered out of the box
 func RegisterDecoder(dec Decoder, formats ...string) {
 	decLock.Lock()
@@ -81,44 +103,81 @@ func GetDecoder(format string) Decoder {
 	return decoders[strings.ToLower(format)]
 }
 
+// RegisterEncoder add a new encoder to the system, json is registered out of the box
+func RegisterEncoder(enc Encoder, formats ...string) {
+	encLock.Lock()
+	defer encLock.Unlock()
+
+	for _, format := range formats {
+		format := strings.ToLower(format)
+
+		_, alreadyExists := encoders[format]
+		if alreadyExists {
+			log.Fatalf("encoder for format %q is already registered: you can have only one", format)
+		}
+
+		encoders[format] = enc
+	}
+}
+
+// GetEncoder returns the encoder based on its name, it may returns nil if the encoder is not
+// registered
+func GetEncoder(format string) Encoder {
+	encLock.RLock()
+	defer encLock.RUnlock()
+
+	return encoders[strings.ToLower(format)]
+}
+
 type streamLayer struct {
-	c      chan map[string]interface{}
+	lock   sync.RWMutex
+	data   map[string]interface{}
+	sender *Sender
 	cipher Cipher
 }
 
 func (sl *streamLayer) Load() map[string]interface{} {
-	return <-sl.c
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	return sl.data
 }
 
 func (sl *streamLayer) Watch() <-chan map[string]interface{} {
-	return sl.c
+	return sl.sender.Watch()
 }
 
-func (sl *streamLayer) Reload(ctx context.Context, r io.Reader, format string) error {
+func (sl *streamLayer) decode(ctx context.Context, r io.Reader, format string) (map[string]interface{}, error) {
 	dec := GetDecoder(format)
 	if dec == nil {
-		return fmt.Errorf("format %q is not registered", format)
+		return nil, fmt.Errorf("format %q is not registered", format)
 	}
 	dr, err := decrypt(sl.cipher, r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	data, err := dec.Decode(ctx, dr)
+	return dec.Decode(ctx, dr)
+}
+
+func (sl *streamLayer) Reload(ctx context.Context, r io.Reader, format string) error {
+	data, err := sl.decode(ctx, r, format)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		select {
-		case sl.c <- data:
-		case <-ctx.Done():
-		}
-	}()
-
+	sl.push(data)
 	return nil
 }
 
+func (sl *streamLayer) push(data map[string]interface{}) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	sl.data = data
+	sl.sender.Send(data)
+}
+
 // NewStreamLayerContext try to create a layer based on a stream, the format should be a registered
 // format (see RegisterDecoder) and if the Cipher is not nil, it pass data to cipher first.
 // A nil cipher is accepted as plain cipher
@@ -127,14 +186,15 @@ func NewStreamLayerContext(ctx context.Context, r io.Reader, format string, c Ci
 		return nil, fmt.Errorf("nil stream")
 	}
 	sl := &streamLayer{
-		c:      make(chan map[string]interface{}),
 		cipher: c,
 	}
 
-	err := sl.Reload(ctx, r, format)
+	data, err := sl.decode(ctx, r, format)
 	if err != nil {
 		return nil, err
 	}
+	sl.data = data
+	sl.sender = NewSender(ctx)
 
 	return sl, nil
 }
@@ -144,16 +204,118 @@ func NewStreamLayer(r io.Reader, format string, c Cipher) (Layer, error) {
 	return NewStreamLayerContext(context.Background(), r, format, c)
 }
 
-// NewFileLayerContext create a new file layer. it choose the format base on the extension
+type fileLayer struct {
+	*streamLayer
+	ext  string
+	open func() (io.ReadCloser, error)
+
+	// path is empty for the files in a fs.FS, they are read only
+	path    string
+	changes ChangeSet
+}
+
+// ReloadLayer reads the file again
+func (fl *fileLayer) ReloadLayer(ctx context.Context) error {
+	f, err := fl.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return fl.Reload(ctx, f, fl.ext)
+}
+
+// Set stages a change in the file, see WritableLayer
+func (fl *fileLayer) Set(key string, value interface{}) error {
+	return fl.changes.Set(key, value)
+}
+
+// Delete stages a delete in the file, see WritableLayer
+func (fl *fileLayer) Delete(key string) error {
+	return fl.changes.Delete(key)
+}
+
+// Commit reads the file again, applies the changes and writes it back in the same format. the file
+// is replaced atomically. the whole data is encoded again, so the comments and the order of the
+// keys are lost, use the onionwriter.EditFile for the hand maintained yaml and toml files
+func (fl *fileLayer) Commit(ctx context.Context) error {
+	if fl.path == "" || fl.cipher != nil {
+		return ErrReadOnly
+	}
+	enc := GetEncoder(fl.ext)
+	if enc == nil {
+		return fmt.Errorf("there is no encoder for format %q", fl.ext)
+	}
+
+	f, err := fl.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	data, err := GetDecoder(fl.ext).Decode(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	return fl.changes.Commit(data, func(data map[string]interface{}) error {
+		err := WriteFile(fl.path, func(w io.Writer) error {
+			return enc.Encode(ctx, w, data)
+		})
+		if err != nil {
+			return err
+		}
+		fl.push(data)
+		return nil
+	})
+}
+
+func newFileLayer(ctx context.Context, ext string, open func() (io.ReadCloser, error), c Cipher) (*fileLayer, error) {
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	l, err := NewStreamLayerContext(ctx, f, ext, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileLayer{
+		streamLayer: l.(*streamLayer),
+		ext:         ext,
+		open:        open,
+	}, nil
+}
+
+// NewFileLayerContext create a new file layer. it choose the format base on the extension.
+// the layer implements the Reloader interface to read the file again, and the WritableLayer if
+// the file is not encrypted and the format has an encoder (see RegisterEncoder)
 func NewFileLayerContext(ctx context.Context, path string, c Cipher) (Layer, error) {
 	ext := strings.TrimPrefix(filepath.Ext(path), ".")
-	f, err := os.Open(path)
+	fl, err := newFileLayer(ctx, ext, func() (io.ReadCloser, error) { return os.Open(path) }, c)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = f.Close() }()
+	fl.path = path
+	return fl, nil
+}
+
+// NewFSFileLayerContext create a new file layer from a file system, like embed.FS, zip.Reader or
+// fstest.MapFS. the path is in the fs.FS format (slash separated) and the format is chosen based
+// on the extension, the same as NewFileLayerContext
+func NewFSFileLayerContext(ctx context.Context, fsys fs.FS, name string, c Cipher) (Layer, error) {
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	fl, err := newFileLayer(ctx, ext, func() (io.ReadCloser, error) { return fsys.Open(name) }, c)
+	if err != nil {
+		return nil, err
+	}
+	return fl, nil
+}
 
-	return NewStreamLayerContext(ctx, f, ext, c)
+// NewFSFileLayer create a new file layer from a file system, see NewFSFileLayerContext
+func NewFSFileLayer(fsys fs.FS, name string, c Cipher) (Layer, error) {
+	return NewFSFileLayerContext(context.Background(), fsys, name, c)
 }
 
 // NewFileLayer create a new file layer. it choose the format base on the extension
@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"testing"
+	"testing/fstest"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -65,3 +66,27 @@ func TestRegisterDecoder(t *testing.T) {
 		So(err, ShouldNotBeNil)
 	})
 }
+
+func TestNewFSFileLayer(t *testing.T) {
+	Convey("File layer from fs.FS", t, func() {
+		fsys := fstest.MapFS{
+			"conf/app.json": &fstest.MapFile{Data: []byte(validJSON)},
+			"conf/app.xyz":  &fstest.MapFile{Data: []byte(validJSON)},
+		}
+		l, err := NewFSFileLayer(fsys, "conf/app.json", nil)
+		So(err, ShouldBeNil)
+		o := New(l)
+		So(o.GetString("string"), ShouldEqual, "str")
+
+		fsys["conf/app.json"].Data = []byte(`{"string": "new"}`)
+		watch := o.ReloadWatch()
+		So(o.Reload(context.Background())[0].Err, ShouldBeNil)
+		<-watch
+		So(o.GetString("string"), ShouldEqual, "new")
+
+		_, err = NewFSFileLayer(fsys, "conf/missing.json", nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewFSFileLayer(fsys, "conf/app.xyz", nil)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,5 +1,35 @@
 package onion
 
+import "strings"
+
+// MultiError is the errors of more than one layer or key, the errors.Is and errors.As check all
+// of them
+type MultiError []error
+
+func (me MultiError) Error() string {
+	msgs := make([]string, 0, len(me))
+	for _, err := range me {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors
+func (me MultiError) Unwrap() []error {
+	return me
+}
+
+// joinErrors returns nil for no error, the error itself for one error and the MultiError for more
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return append(MultiError(nil), errs...)
+}
+
 // MergeLayersData is an helper function to merge more layers in one.
 // Following slice order, a previous layer key is overriden by an equal key in
 // next layer.
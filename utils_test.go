@@ -1,6 +1,7 @@
 package onion
 
 import (
+	"errors"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -47,3 +48,16 @@ func TestMergeLayer(t *testing.T) {
 		So(len(merged), ShouldEqual, 0)
 	})
 }
+
+func TestMultiError(t *testing.T) {
+	Convey("Join the errors", t, func() {
+		err1, err2 := errors.New("first"), errors.New("second")
+		So(joinErrors(nil), ShouldBeNil)
+		So(joinErrors([]error{err1}), ShouldEqual, err1)
+
+		err := joinErrors([]error{err1, err2})
+		So(err.Error(), ShouldEqual, "first; second")
+		So(errors.Is(err, err1), ShouldBeTrue)
+		So(errors.Is(err, err2), ShouldBeTrue)
+	})
+}
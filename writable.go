@@ -0,0 +1,199 @@
+package onion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrReadOnly is returned on Commit when the layer can not be written, like the encrypted files
+var ErrReadOnly = errors.New("the layer is read only")
+
+// WritableLayer is an optional interface for the layers that can write the changes back to their
+// source, like the file, etcd and map layers. the keys are separated by dot, unless the layer has
+// another delimiter.
+type WritableLayer interface {
+	Layer
+	// Set stages a new value for the key
+	Set(key string, value interface{}) error
+	// Delete stages removing the key
+	Delete(key string) error
+	// Commit writes the staged changes to the source, the new data is sent to the watch channel
+	Commit(ctx context.Context) error
+}
+
+type change struct {
+	path   []string
+	value  interface{}
+	delete bool
+}
+
+// ChangeSet is the list of the staged changes of a writable layer, the zero value is ready to use
+type ChangeSet struct {
+	// Delimiter is used to split the keys, empty means "."
+	Delimiter string
+
+	lock    sync.Mutex
+	changes []change
+}
+
+func (cs *ChangeSet) delimiter() string {
+	if cs.Delimiter == "" {
+		return "."
+	}
+	return cs.Delimiter
+}
+
+// SplitKey splits the key by the delimiter, the empty parts are not allowed
+func SplitKey(key, delimiter string) ([]string, error) {
+	path := strings.Split(key, delimiter)
+	for i := range path {
+		if path[i] == "" {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return path, nil
+}
+
+// Set stages setting the key
+func (cs *ChangeSet) Set(key string, value interface{}) error {
+	path, err := SplitKey(key, cs.delimiter())
+	if err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.changes = append(cs.changes, change{path: path, value: value})
+	return nil
+}
+
+// Delete stages removing the key
+func (cs *ChangeSet) Delete(key string) error {
+	path, err := SplitKey(key, cs.delimiter())
+	if err != nil {
+		return err
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.changes = append(cs.changes, change{path: path, delete: true})
+	return nil
+}
+
+// Commit applies the changes on the data and calls the write function with the result, the
+// changes are cleared only if the write is successful. the data is not modified. if there is no
+// change, the write is not called
+func (cs *ChangeSet) Commit(data map[string]interface{}, write func(map[string]interface{}) error) error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if len(cs.changes) == 0 {
+		return nil
+	}
+
+	var ok bool
+	for _, c := range cs.changes {
+		if c.delete {
+			data = deletePath(data, c.path...)
+			continue
+		}
+		if data, ok = setPath(data, c.value, c.path...); !ok {
+			return fmt.Errorf("can not set %q, the parent key is not a map", strings.Join(c.path, cs.delimiter()))
+		}
+	}
+
+	if err := write(data); err != nil {
+		return err
+	}
+	cs.changes = nil
+	return nil
+}
+
+// WriteFile writes the file with the write function, it writes into a temp file in the same
+// directory and renames it so the file is replaced atomically. the mode of the file is kept
+func WriteFile(path string, write func(io.Writer) error) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyMap returns a copy of the first level of the map, the yaml maps are converted
+func copyMap(v interface{}) (map[string]interface{}, bool) {
+	res := make(map[string]interface{})
+	switch m := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k := range m {
+			res[k] = m[k]
+		}
+	case map[interface{}]interface{}:
+		for k := range m {
+			res[fmt.Sprint(k)] = m[k]
+		}
+	default:
+		return nil, false
+	}
+	return res, true
+}
+
+// setPath returns a copy of the map with the value set, only the maps in the path are copied. it
+// returns false if one of the parents is not a map
+func setPath(m map[string]interface{}, v interface{}, path ...string) (map[string]interface{}, bool) {
+	res, _ := copyMap(m)
+	if len(path) == 1 {
+		res[path[0]] = v
+		return res, true
+	}
+
+	child, ok := copyMap(res[path[0]])
+	if !ok {
+		return nil, false
+	}
+	if child, ok = setPath(child, v, path[1:]...); !ok {
+		return nil, false
+	}
+	res[path[0]] = child
+	return res, true
+}
+
+// deletePath returns a copy of the map without the key, a missing key is ignored
+func deletePath(m map[string]interface{}, path ...string) map[string]interface{} {
+	if _, ok := searchStringMap(m, path...); !ok {
+		return m
+	}
+
+	res, _ := copyMap(m)
+	if len(path) == 1 {
+		delete(res, path[0])
+		return res
+	}
+	child, _ := copyMap(res[path[0]])
+	res[path[0]] = deletePath(child, path[1:]...)
+	return res
+}
@@ -0,0 +1,128 @@
+package onion
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type plainCipher struct{}
+
+func (plainCipher) Decrypt(r io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(r)
+}
+
+func TestMapLayerWrite(t *testing.T) {
+	Convey("Write into a map layer", t, func() {
+		data := map[string]interface{}{
+			"db": map[string]interface{}{"host": "localhost", "port": 5432},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		l := NewMapLayerContext(ctx, data)
+		o := NewContext(ctx, l)
+		wl, ok := l.(WritableLayer)
+		So(ok, ShouldBeTrue)
+
+		So(wl.Set("db.host", "db.local"), ShouldBeNil)
+		So(wl.Set("cache.ttl", "1m"), ShouldBeNil)
+		So(wl.Delete("db.port"), ShouldBeNil)
+		So(wl.Delete("not.exists"), ShouldBeNil)
+		So(o.GetString("db.host"), ShouldEqual, "localhost")
+
+		watch := o.ReloadWatch()
+		So(wl.Commit(context.Background()), ShouldBeNil)
+		<-watch
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetString("cache.ttl"), ShouldEqual, "1m")
+		So(o.GetInt("db.port"), ShouldEqual, 0)
+		So(data["db"].(map[string]interface{})["host"], ShouldEqual, "localhost")
+
+		// Nothing to commit
+		So(wl.Commit(context.Background()), ShouldBeNil)
+
+		So(wl.Set("db..host", 1), ShouldNotBeNil)
+		So(wl.Delete(""), ShouldNotBeNil)
+		So(wl.Set("db.host.name", "x"), ShouldBeNil)
+		So(wl.Commit(context.Background()), ShouldNotBeNil)
+
+		cancel()
+		_, open := <-l.Watch()
+		So(open, ShouldBeFalse)
+	})
+
+	Convey("Write with another delimiter", t, func() {
+		l := NewMapLayer().(*mapLayer)
+		So(l.Watch(), ShouldBeNil)
+		l.changes.Delimiter = "/"
+		So(l.Set("db/host", "db.local"), ShouldBeNil)
+		So(l.Set("db//port", 1), ShouldNotBeNil)
+		So(l.Commit(context.Background()), ShouldBeNil)
+		So(l.Load(), ShouldResemble, map[string]interface{}{"db": map[string]interface{}{"host": "db.local"}})
+	})
+}
+
+func TestFileLayerWrite(t *testing.T) {
+	Convey("Write back into the file", t, func() {
+		dir, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		path := filepath.Join(dir, "app.json")
+		So(ioutil.WriteFile(path, []byte(`{"db": {"host": "localhost"}, "debug": true}`), 0600), ShouldBeNil)
+		l, err := NewFileLayer(path, nil)
+		So(err, ShouldBeNil)
+		o := New(l)
+		wl := l.(WritableLayer)
+
+		// The changes in the file after the load are kept
+		So(ioutil.WriteFile(path, []byte(`{"db": {"host": "localhost"}, "debug": false}`), 0600), ShouldBeNil)
+		So(wl.Set("db.host", "db.local"), ShouldBeNil)
+		watch := o.ReloadWatch()
+		So(wl.Commit(context.Background()), ShouldBeNil)
+		<-watch
+		So(o.GetString("db.host"), ShouldEqual, "db.local")
+		So(o.GetBoolDefault("debug", true), ShouldBeFalse)
+
+		fi, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+		l2, err := NewFileLayer(path, nil)
+		So(err, ShouldBeNil)
+		So(New(l2).GetString("db.host"), ShouldEqual, "db.local")
+
+		files, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(files, ShouldHaveLength, 1)
+	})
+
+	Convey("The read only files", t, func() {
+		fsys := fstest.MapFS{"app.json": &fstest.MapFile{Data: []byte(`{"a": 1}`)}}
+		l, err := NewFSFileLayer(fsys, "app.json", nil)
+		So(err, ShouldBeNil)
+		So(l.(WritableLayer).Set("a", 2), ShouldBeNil)
+		So(l.(WritableLayer).Commit(context.Background()), ShouldEqual, ErrReadOnly)
+
+		dir, err := ioutil.TempDir("", "onion-test-")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := filepath.Join(dir, "app.json")
+		So(ioutil.WriteFile(path, []byte(`{"a": 1}`), 0644), ShouldBeNil)
+		l, err = NewFileLayer(path, plainCipher{})
+		So(err, ShouldBeNil)
+		So(l.(WritableLayer).Commit(context.Background()), ShouldEqual, ErrReadOnly)
+	})
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	Convey("Get the encoders", t, func() {
+		So(GetEncoder("JSON"), ShouldNotBeNil)
+		So(GetEncoder("not-a-format"), ShouldBeNil)
+	})
+}